@@ -0,0 +1,196 @@
+// Package agent captures CPU profiles of the running process and pushes them to cpgo,
+// so services without an exposed net/http/pprof endpoint are profiled too.
+//
+//	a, err := agent.Start(agent.Options{
+//		URL:       "http://cpgo:8080",
+//		BackendID: "checkout",
+//		Token:     os.Getenv("CPGO_PUSH_TOKEN"),
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	defer a.Stop()
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/macabu/cpgo/internal/retry"
+)
+
+const (
+	// DefaultProfileDuration matches the default of the net/http/pprof CPU profile handler.
+	DefaultProfileDuration = 30 * time.Second
+	// DefaultDutyCycle profiles 10% of the time, keeping the overhead negligible.
+	DefaultDutyCycle = 0.1
+	// maxMessageSize bounds how much of an error response is read.
+	maxMessageSize = 1 << 10
+)
+
+type Options struct {
+	// URL of the cpgo ingestion server, e.g. http://cpgo:8080.
+	URL string
+	// BackendID in the cpgo config, which must accept pushed profiles.
+	BackendID string
+	// Token expected by the backend, sent as a bearer token.
+	Token string
+	// ProfileDuration of each CPU profile, defaults to DefaultProfileDuration.
+	ProfileDuration time.Duration
+	// DutyCycle is the fraction of time spent profiling, defaults to DefaultDutyCycle.
+	// E.g. 0.1 with a ProfileDuration of 30s captures a profile every 5 minutes.
+	DutyCycle float64
+	// Client pushing the profiles, defaults to http.DefaultClient.
+	Client *http.Client
+	// MaxAttempts at pushing a profile, with an exponential backoff in between. Defaults to 3.
+	MaxAttempts int
+	// OnError is called for every profile that could not be captured or pushed, errors are discarded when nil.
+	OnError func(error)
+}
+
+// Agent captures and pushes profiles in the background, until stopped.
+type Agent struct {
+	opts     Options
+	retry    retry.Policy
+	endpoint string
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// Start capturing and pushing profiles in the background. The first profile is captured right away.
+func Start(opts Options) (*Agent, error) {
+	if opts.URL == "" {
+		return nil, ErrNoURL
+	}
+
+	if opts.BackendID == "" {
+		return nil, ErrNoBackendID
+	}
+
+	if opts.DutyCycle == 0 {
+		opts.DutyCycle = DefaultDutyCycle
+	}
+
+	if opts.DutyCycle < 0 || opts.DutyCycle > 1 {
+		return nil, fmt.Errorf("%v: %w", opts.DutyCycle, ErrInvalidDutyCycle)
+	}
+
+	if opts.ProfileDuration <= 0 {
+		opts.ProfileDuration = DefaultProfileDuration
+	}
+
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	if opts.OnError == nil {
+		opts.OnError = func(error) {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	retryPolicy := retry.DefaultPolicy
+	if opts.MaxAttempts > 0 {
+		retryPolicy.MaxAttempts = opts.MaxAttempts
+	}
+
+	a := &Agent{
+		opts:     opts,
+		retry:    retryPolicy,
+		endpoint: strings.TrimSuffix(opts.URL, "/") + "/api/v1/backends/" + url.PathEscape(opts.BackendID) + "/profiles",
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	go a.loop(ctx)
+
+	return a, nil
+}
+
+// Stop capturing profiles, discarding the one in progress. Returns once the agent stopped.
+func (a *Agent) Stop() {
+	a.cancel()
+
+	<-a.done
+}
+
+// loop captures and pushes a profile, then pauses for the rest of the duty cycle.
+func (a *Agent) loop(ctx context.Context) {
+	defer close(a.done)
+
+	pause := time.Duration(float64(a.opts.ProfileDuration)/a.opts.DutyCycle) - a.opts.ProfileDuration
+
+	for {
+		if err := a.captureAndPush(ctx); err != nil && ctx.Err() == nil {
+			a.opts.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pause):
+		}
+	}
+}
+
+// captureAndPush a single CPU profile of ProfileDuration.
+func (a *Agent) captureAndPush(ctx context.Context) error {
+	var buf bytes.Buffer
+
+	// Fails if another CPU profile is in progress, e.g. requested through net/http/pprof.
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		return fmt.Errorf("pprof.StartCPUProfile: %w", err)
+	}
+
+	timer := time.NewTimer(a.opts.ProfileDuration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		pprof.StopCPUProfile()
+
+		return ctx.Err()
+	case <-timer.C:
+		pprof.StopCPUProfile()
+	}
+
+	return retry.Do(ctx, a.retry, func(ctx context.Context) error {
+		return a.push(ctx, buf.Bytes())
+	})
+}
+
+// push the profile to the backend, in a single attempt.
+func (a *Agent) push(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("http.NewRequestWithContext: %w", err))
+	}
+
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	if a.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.opts.Token)
+	}
+
+	resp, err := a.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("client.Do: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, maxMessageSize))
+
+		return &UploadError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+
+	return nil
+}
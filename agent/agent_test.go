@@ -0,0 +1,139 @@
+package agent_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/agent"
+)
+
+func TestStart(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		name        string
+		opts        agent.Options
+		expectedErr error
+	}{
+		{
+			name:        "when there is no url, then an error is returned",
+			opts:        agent.Options{BackendID: "checkout"},
+			expectedErr: agent.ErrNoURL,
+		},
+		{
+			name:        "when there is no backend id, then an error is returned",
+			opts:        agent.Options{URL: "http://cpgo:8080"},
+			expectedErr: agent.ErrNoBackendID,
+		},
+		{
+			name:        "when the duty cycle is above 1, then an error is returned",
+			opts:        agent.Options{URL: "http://cpgo:8080", BackendID: "checkout", DutyCycle: 1.5},
+			expectedErr: agent.ErrInvalidDutyCycle,
+		},
+	}
+
+	for _, tt := range testcases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			a, err := agent.Start(tt.opts)
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.Nil(t, a)
+		})
+	}
+}
+
+// The CPU profiler is global to the process, so only this test captures profiles.
+func TestAgent(t *testing.T) {
+	t.Parallel()
+
+	t.Run("given a running agent, then it pushes CPU profiles to the backend", func(t *testing.T) {
+		uploads := make(chan *http.Request, 10)
+		bodies := make(chan []byte, 10)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+
+			uploads <- r
+			bodies <- body
+
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		t.Cleanup(srv.Close)
+
+		a, err := agent.Start(agent.Options{
+			URL:             srv.URL,
+			BackendID:       "checkout",
+			Token:           "secret",
+			ProfileDuration: 50 * time.Millisecond,
+			DutyCycle:       0.5,
+			OnError:         func(err error) { t.Errorf("unexpected error: %v", err) },
+		})
+		require.NoError(t, err)
+
+		var req *http.Request
+
+		select {
+		case req = <-uploads:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no profile was pushed")
+		}
+
+		a.Stop()
+
+		require.Equal(t, http.MethodPost, req.Method)
+		require.Equal(t, "/api/v1/backends/checkout/profiles", req.URL.Path)
+		require.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+
+		prof, err := profile.ParseData(<-bodies)
+		require.NoError(t, err)
+		require.Equal(t, "samples", prof.SampleType[0].Type)
+	})
+
+	t.Run("when the backend rejects the profile, then the error is reported without retrying", func(t *testing.T) {
+		attempts := make(chan struct{}, 10)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			attempts <- struct{}{}
+
+			http.Error(w, "missing or invalid bearer token", http.StatusUnauthorized)
+		}))
+		t.Cleanup(srv.Close)
+
+		errs := make(chan error, 10)
+
+		a, err := agent.Start(agent.Options{
+			URL:             srv.URL,
+			BackendID:       "checkout",
+			ProfileDuration: 50 * time.Millisecond,
+			// Long enough so that a single profile is captured during the test.
+			DutyCycle: 0.01,
+			OnError:   func(err error) { errs <- err },
+		})
+		require.NoError(t, err)
+
+		var uploadErr *agent.UploadError
+
+		select {
+		case err := <-errs:
+			require.True(t, errors.As(err, &uploadErr))
+		case <-time.After(5 * time.Second):
+			t.Fatal("no error was reported")
+		}
+
+		a.Stop()
+
+		require.Equal(t, http.StatusUnauthorized, uploadErr.StatusCode)
+		require.Equal(t, "missing or invalid bearer token", uploadErr.Message)
+		require.Len(t, attempts, 1)
+	})
+}
@@ -0,0 +1,29 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/macabu/cpgo/internal/retry"
+)
+
+var (
+	ErrNoURL            = errors.New("no url of the cpgo ingestion server")
+	ErrNoBackendID      = errors.New("no backend id to push the profiles to")
+	ErrInvalidDutyCycle = errors.New("duty cycle must be greater than 0 and at most 1")
+)
+
+// UploadError is returned when cpgo rejects a pushed profile.
+type UploadError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("unexpected status code %v: %v", e.StatusCode, e.Message)
+}
+
+// Permanent reports whether pushing the same profile would fail again, e.g. due to an invalid token.
+func (e *UploadError) Permanent() bool {
+	return !retry.IsTransientStatus(e.StatusCode)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/macabu/cpgo/internal/config"
+	"github.com/macabu/cpgo/internal/continuous"
+	"github.com/macabu/cpgo/internal/discovery"
+	"github.com/macabu/cpgo/internal/gitops/gh"
+	"github.com/macabu/cpgo/internal/httpclient"
+	"github.com/macabu/cpgo/internal/ingest"
+	"github.com/macabu/cpgo/internal/pprof"
+	"github.com/macabu/cpgo/internal/retry"
+	"github.com/macabu/cpgo/internal/s3"
+	"github.com/macabu/cpgo/internal/store"
+)
+
+const (
+	// readHeaderTimeout of the ingestion server, the bodies are bounded by their maximum size instead.
+	readHeaderTimeout = 10 * time.Second
+	// shutdownTimeout for the uploads in flight when stopping.
+	shutdownTimeout = 30 * time.Second
+)
+
+// job of a backend, holding the state kept across its runs.
+type job struct {
+	backend     config.Backend
+	ghClient    *gh.Client
+	retryPolicy retry.Policy
+	// The clients are built once, so that their connections are reused across runs.
+	scrapeClient   *http.Client
+	downloadClient *http.Client
+	// kube discovers the pods of the backend, nil unless configured.
+	kube *discovery.Kubernetes
+	// s3Client of the bucket of the backend, nil unless configured.
+	s3Client *s3.Client
+	// querier of the continuous profiling server, nil unless configured.
+	querier continuous.Querier
+	// inbox buffers the pushed profiles, nil unless the backend accepts them.
+	inbox *store.Store
+	// sampler samples the targets across the window, nil unless the backend has one.
+	sampler *pprof.Sampler
+	// samples keeps the samples taken by the sampler until they are merged.
+	samples *store.Store
+	// filter is applied to the new profiles before merging.
+	filter *pprof.Filter
+	// retention keeps the profiles of the sliding window, nil unless the backend has one.
+	retention *pprof.Retention
+	// queryCheckpoint keeps the end of the range queried from the continuous profiling server, nil unless the PGO file
+	// is merged with the previous ones, as each run must then only query what is new.
+	queryCheckpoint *store.Checkpoint
+	// s3Checkpoint keeps track of the objects of the bucket already merged, nil unless only the newer ones are loaded.
+	s3Checkpoint *store.Checkpoint
+}
+
+// newJobs for every backend of the config.
+func newJobs(cfg *config.Config, ghClient *gh.Client) ([]*job, error) {
+	jobs := make([]*job, 0, len(cfg.Backends))
+
+	// The backend credentials must not be sent to the Git forge, hence a client without them.
+	downloadClient, err := httpclient.New(httpclient.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("httpclient.New: %w", err)
+	}
+
+	for _, backend := range cfg.Backends {
+		retryPolicy := cfg.RetryPolicy(backend)
+
+		labelWeights := make([]pprof.LabelWeight, 0, len(backend.Filter.LabelWeights))
+
+		for _, labelWeight := range backend.Filter.LabelWeights {
+			labelWeights = append(labelWeights, pprof.LabelWeight{
+				Labels: labelWeight.Labels,
+				Weight: labelWeight.Weight,
+			})
+		}
+
+		filter, err := pprof.NewFilter(pprof.FilterOptions{
+			Focus:        backend.Filter.Focus,
+			Ignore:       backend.Filter.Ignore,
+			Hide:         backend.Filter.Hide,
+			PruneFrom:    backend.Filter.PruneFrom,
+			KeepLabels:   backend.Filter.KeepLabels,
+			DropLabels:   backend.Filter.DropLabels,
+			LabelWeights: labelWeights,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("pprof.NewFilter: %w", err)
+		}
+
+		j := &job{
+			backend:        backend,
+			ghClient:       ghClient.WithRetryPolicy(retryPolicy),
+			retryPolicy:    retryPolicy,
+			downloadClient: downloadClient,
+			filter:         filter,
+		}
+
+		if err := j.newClients(); err != nil {
+			return nil, err
+		}
+
+		if backend.Push != nil {
+			inbox, err := store.New(filepath.Join(cfg.DataDir, "inbox", backend.ID))
+			if err != nil {
+				return nil, fmt.Errorf("store.New: %w", err)
+			}
+
+			j.inbox = inbox
+		}
+
+		if backend.S3 != nil && backend.S3.NewerThanLastRun {
+			s3Checkpoint, err := store.NewCheckpoint(filepath.Join(cfg.DataDir, "s3", backend.ID+".json"))
+			if err != nil {
+				return nil, fmt.Errorf("store.NewCheckpoint: %w", err)
+			}
+
+			j.s3Checkpoint = s3Checkpoint
+		}
+
+		if backend.ContinuousProfiling != nil && backend.MergeStrategy.Mode != string(pprof.MergeReplace) {
+			queryCheckpoint, err := store.NewCheckpoint(filepath.Join(cfg.DataDir, "continuous", backend.ID+".json"))
+			if err != nil {
+				return nil, fmt.Errorf("store.NewCheckpoint: %w", err)
+			}
+
+			j.queryCheckpoint = queryCheckpoint
+		}
+
+		if samplingCfg := backend.Sampling; samplingCfg != nil {
+			samples, err := store.New(filepath.Join(cfg.DataDir, "samples", backend.ID))
+			if err != nil {
+				return nil, fmt.Errorf("store.New: %w", err)
+			}
+
+			j.samples = samples
+			// The targets are discovered anew for every sample, as they may change across the window.
+			j.sampler = pprof.NewSampler(pprof.SourceFunc(j.scrape), samples, pprof.SamplerOptions{
+				Samples: samplingCfg.Samples,
+				Window:  samplingCfg.Window,
+			})
+		}
+
+		if strategy := backend.MergeStrategy; strategy.Mode == config.MergeModeRetention {
+			retained, err := store.New(filepath.Join(cfg.DataDir, "retention", backend.ID))
+			if err != nil {
+				return nil, fmt.Errorf("store.New: %w", err)
+			}
+
+			j.retention = pprof.NewRetention(retained, pprof.RetentionOptions{
+				MaxProfiles: strategy.MaxProfiles,
+				MaxAge:      strategy.MaxAge,
+			})
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+// newClients of the sources of the backend.
+func (j *job) newClients() error {
+	backend := j.backend
+
+	scrapeClient, err := newScrapeClient(backend)
+	if err != nil {
+		return fmt.Errorf("newScrapeClient: %w", err)
+	}
+
+	j.scrapeClient = scrapeClient
+
+	if backend.Discovery.Kubernetes != nil {
+		j.kube, err = newKubernetes(backend)
+		if err != nil {
+			return fmt.Errorf("newKubernetes: %w", err)
+		}
+	}
+
+	if backend.S3 != nil {
+		j.s3Client, err = newS3Client(backend)
+		if err != nil {
+			return fmt.Errorf("newS3Client: %w", err)
+		}
+	}
+
+	if continuousCfg := backend.ContinuousProfiling; continuousCfg != nil {
+		j.querier, err = continuous.New(continuousCfg.Kind, scrapeClient, continuous.Options{
+			URL:         continuousCfg.URL,
+			MaxBodySize: backend.HTTP.MaxBodySize,
+		})
+		if err != nil {
+			return fmt.Errorf("continuous.New: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// newIngestionServer accepting the profiles pushed to the jobs with an inbox.
+func newIngestionServer(addr string, cfg *config.Config, jobs []*job) *http.Server {
+	inboxes := make(map[string]ingest.Inbox)
+
+	for _, j := range jobs {
+		if j.inbox == nil {
+			continue
+		}
+
+		inboxes[j.backend.ID] = ingest.Inbox{
+			TokenFile: j.backend.Push.TokenFile,
+			TokenEnv:  j.backend.Push.TokenEnv,
+			Store:     j.inbox,
+		}
+	}
+
+	return &http.Server{
+		Addr: addr,
+		Handler: ingest.NewServer(inboxes, ingest.Options{
+			MaxBodySize: cfg.Ingestion.MaxBodySize,
+			Logger:      log.Logger,
+		}),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+}
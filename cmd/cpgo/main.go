@@ -54,26 +54,77 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to parse config")
 	}
 
+	for _, backend := range cfg.Backends {
+		// The pushed profiles are only received by the ingestion server.
+		if backend.Push != nil && flags.ListenAddr == "" {
+			log.Fatal().Str("backend_id", backend.ID).Msg("Backend accepts pushed profiles, but no listen address is set")
+		}
+	}
+
+	jobs, err := newJobs(cfg, ghClient)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up backends")
+	}
+
 	s := gocron.NewScheduler(time.UTC)
 	s.SetMaxConcurrentJobs(runtime.NumCPU()-1, gocron.WaitMode)
+	// A run of a backend must not overlap with its previous one, as they share the state of the job.
+	s.SingletonModeAll()
 
-	for i, backend := range cfg.Backends {
-		_, err := s.Cron(backend.Schedule).Do(func() {
-			if err := run(ctx, backend, ghClient); err != nil {
-				log.Error().Err(err).Str("backend_url", backend.URL).Str("repo", backend.OpenPR.Repo).Msg("Failed to process backend")
+	samples := &sampleScheduler{scheduler: s}
+
+	for i, j := range jobs {
+		_, err := s.Cron(j.backend.Schedule).Do(func() {
+			if j.sampler != nil {
+				if err := samples.scheduleSamples(ctx, j); err != nil {
+					log.Error().Err(err).Str("backend_id", j.backend.ID).Msg("Failed to schedule samples")
+				}
+			}
+
+			if err := j.run(ctx); err != nil {
+				log.Error().
+					Err(err).
+					Str("backend_url", j.backend.URL).
+					Str("repo", j.backend.OpenPR.Repo).
+					Msg("Failed to process backend")
 			}
 		})
 		if err != nil {
 			log.Fatal().Err(err).Msg("Failed to schedule run")
 		}
 
-		log.Info().Str("backend_url", backend.URL).Str("repo", backend.OpenPR.Repo).Msgf("[%v] Scheduled job!", i+1)
+		log.Info().Str("backend_url", j.backend.URL).Str("repo", j.backend.OpenPR.Repo).Msgf("[%v] Scheduled job!", i+1)
+	}
+
+	var srv *http.Server
+
+	if flags.ListenAddr != "" {
+		srv = newIngestionServer(flags.ListenAddr, cfg, jobs)
+
+		go func() {
+			log.Info().Str("addr", flags.ListenAddr).Msg("Listening for pushed profiles")
+
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal().Err(err).Msg("Failed to serve the ingestion endpoint")
+			}
+		}()
 	}
 
 	go func() {
 		<-sigChan
 
 		cancel()
+
+		// Before stopping the scheduler, which makes main return, so the uploads in flight are buffered.
+		if srv != nil {
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancelShutdown()
+
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				log.Warn().Err(err).Msg("Failed to shut down the ingestion server gracefully")
+			}
+		}
+
 		s.Stop()
 
 		log.Debug().Msg("Stopping schedules, and going to sleep. Bye bye!")
@@ -82,23 +133,54 @@ func main() {
 	s.StartBlocking()
 }
 
-func run(ctx context.Context, backend config.Backend, ghClient *gh.Client) error {
+func (j *job) run(ctx context.Context) error {
+	backend := j.backend
+	ghClient := j.ghClient
+
 	ghRepo := gh.ParseRepoURL(backend.OpenPR.Repo)
 
-	logger := log.With().Str("url", backend.URL).Str("repo_org", ghRepo.Org).Str("repo_name", ghRepo.Name).Logger()
+	logger := log.With().
+		Str("backend_id", backend.ID).
+		Str("url", backend.URL).
+		Str("repo_org", ghRepo.Org).
+		Str("repo_name", ghRepo.Name).
+		Logger()
 
-	profileFetcher := pprof.NewFetcher(http.DefaultClient)
+	sources, err := j.newSources(ctx)
+	if err != nil {
+		return fmt.Errorf("newSources: %w", err)
+	}
 
-	logger.Debug().Msg("Fetching profile")
+	logger.Debug().Msg("Fetching profiles")
 
-	newProfile, err := profileFetcher.FromURL(ctx, backend.URL)
+	newProfiles, err := fetchProfiles(ctx, sources)
 	if err != nil {
-		return fmt.Errorf("profileFetcher.FromURL: %w", err)
+		logFetchErrors(logger, err)
 	}
 
-	logger.Debug().Int64("profile_duration_ns", newProfile.DurationNanos).Msg("Profile fetched!")
+	if len(newProfiles) == 0 {
+		if err == nil {
+			// e.g. no objects were written to the bucket since the last run.
+			logger.Info().Msg("No new profiles, skipping")
 
-	logger.Debug().Msg("Checking whether there is already another profile")
+			if j.retention != nil {
+				// The window slides nonetheless, so the profiles that fell out of it are not kept forever.
+				if err := j.retention.Evict(); err != nil {
+					return fmt.Errorf("retention.Evict: %w", err)
+				}
+			}
+
+			return commitSources(sources)
+		}
+
+		return fmt.Errorf("fetchProfiles: %w", err)
+	}
+
+	logger.Debug().Int("profiles", len(newProfiles)).Msg("Profiles fetched!")
+
+	for i, newProfile := range newProfiles {
+		newProfiles[i] = j.filter.Apply(newProfile)
+	}
 
 	opts := gh.Options{
 		Repo:       ghRepo,
@@ -106,38 +188,185 @@ func run(ctx context.Context, backend config.Backend, ghClient *gh.Client) error
 		MainBranch: backend.OpenPR.TargetBranch,
 	}
 
-	downloadURL, err := ghClient.ExistingPGOFileURL(ctx, opts)
-	if err != nil && !errors.Is(err, gitops.ErrPGOFileNotFound) {
-		return fmt.Errorf("ghClient.ExistingPGOFileURL: %w", err)
-	}
+	var existingProfile *profile.Profile
 
-	profiles := []*profile.Profile{newProfile}
+	if j.retention != nil {
+		// The PGO file is rebuilt from the window alone, so the existing one is not needed. The new profiles are only
+		// retained once the PGO file is updated.
+		window, err := j.retention.Window(ctx, newProfiles)
+		if err != nil {
+			if len(window) == 0 {
+				return fmt.Errorf("retention.Window: %w", err)
+			}
 
-	if downloadURL != "" {
-		logger.Info().Str("download_url", downloadURL).Msg("Found existing PGO file. Downloading it...")
+			logger.Warn().Err(err).Msg("Failed to load some of the retained profiles")
+		}
 
-		existingProfile, err := profileFetcher.FromURL(ctx, downloadURL)
+		logger.Debug().Int("profiles", len(window)).Msg("Rebuilding from the retained profiles")
+
+		newProfiles = window
+	} else if backend.MergeStrategy.Mode != string(pprof.MergeReplace) {
+		existingProfile, err = j.fetchExistingProfile(ctx, logger, opts)
 		if err != nil {
-			return fmt.Errorf("profileFetcher.FromURL: %w", err)
+			return fmt.Errorf("fetchExistingProfile: %w", err)
 		}
+	}
+
+	mergeOpts, err := newMergeOptions(backend)
+	if err != nil {
+		return fmt.Errorf("newMergeOptions: %w", err)
+	}
 
-		profiles = append(profiles, existingProfile)
+	mergeOpts.Prune = pprof.PruneOptions{
+		Threshold: backend.Prune.Threshold,
+		MaxSize:   backend.Prune.MaxSize,
 	}
+	mergeOpts.Minimal = backend.Output.Minimal
+	mergeOpts.Format = pprof.Format(backend.Output.Format)
 
-	var b bytes.Buffer
+	mergeOpts.OnAdjustment = func(adjustment pprof.Adjustment) {
+		logger.Warn().Stringer("adjustment", adjustment).Msg("Normalized a profile to merge it")
+	}
 
-	if err := pprof.MergeProfiles(&b, profiles); err != nil {
+	var b, companion bytes.Buffer
+
+	if backend.Output.CompanionFile != "" {
+		mergeOpts.Companion = &companion
+	}
+
+	if err := pprof.MergeProfiles(&b, existingProfile, newProfiles, mergeOpts); err != nil {
 		return fmt.Errorf("pprof.MergeProfiles: %w", err)
 	}
 
 	logger.Debug().Msg("Merged profiles!")
 
-	prURL, err := ghClient.UpdatePGOFile(ctx, opts, b.Bytes())
+	var companions []gh.File
+
+	if backend.Output.CompanionFile != "" {
+		companions = append(companions, gh.File{Path: backend.Output.CompanionFile, Content: companion.Bytes()})
+	}
+
+	prURL, err := ghClient.UpdatePGOFile(ctx, opts, b.Bytes(), companions...)
 	if err != nil {
 		return fmt.Errorf("ghClient.UpdatePGOFile: %w", err)
 	}
 
 	logger.Info().Str("pr_url", prURL).Msg("Created new PR")
 
+	if j.retention != nil {
+		// Before consuming the sources, so that the new profiles are not lost if it fails.
+		if err := j.retention.Commit(); err != nil {
+			return fmt.Errorf("retention.Commit: %w", err)
+		}
+	}
+
+	return commitSources(sources)
+}
+
+// fetchExistingProfile downloads the PGO file from the repository, if any. Returns nil when there is none, or when
+// it is not a valid CPU profile and thus has to be replaced.
+func (j *job) fetchExistingProfile(ctx context.Context, logger zerolog.Logger, opts gh.Options) (*profile.Profile, error) {
+	logger.Debug().Msg("Checking whether there is already another profile")
+
+	downloadURL, err := j.ghClient.ExistingPGOFileURL(ctx, opts)
+	if errors.Is(err, gitops.ErrPGOFileNotFound) || (err == nil && downloadURL == "") {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("ghClient.ExistingPGOFileURL: %w", err)
+	}
+
+	profileFetcher := pprof.NewFetcherWithOptions(j.downloadClient, pprof.FetcherOptions{Retry: j.retryPolicy})
+
+	logger.Info().Str("download_url", downloadURL).Msg("Found existing PGO file. Downloading it...")
+
+	existingProfile, err := profileFetcher.FromURL(ctx, downloadURL)
+
+	switch {
+	case errors.Is(err, pprof.ErrUnparsableProfile), errors.Is(err, pprof.ErrNotCPUProfile):
+		// Merging would never succeed, so the broken file is replaced by the new profiles instead.
+		logger.Warn().Err(err).Msg("Existing PGO file is not a valid CPU profile, replacing it")
+
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("profileFetcher.FromURL: %w", err)
+	}
+
+	return existingProfile, nil
+}
+
+// commitSources consumes the profiles of the sources that buffer them, once they were merged.
+func commitSources(sources []pprof.Source) error {
+	var errs []error
+
+	for _, source := range sources {
+		if committer, ok := source.(pprof.Committer); ok {
+			errs = append(errs, committer.Commit())
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("committer.Commit: %w", err)
+	}
+
 	return nil
 }
+
+// newMergeOptions of the backend, deriving the decay from its half-life and schedule if needed.
+func newMergeOptions(backend config.Backend) (pprof.MergeOptions, error) {
+	strategy := backend.MergeStrategy
+
+	switch strategy.Mode {
+	case config.MergeModeRetention:
+		// The profiles of the window are all new, as they are merged from scratch.
+		return pprof.MergeOptions{Mode: pprof.MergeReplace}, nil
+	case string(pprof.MergeDecay):
+		if strategy.HalfLife <= 0 {
+			return pprof.MergeOptions{Mode: pprof.MergeDecay, Decay: strategy.Factor}, nil
+		}
+
+		interval, err := backend.ScheduleInterval()
+		if err != nil {
+			return pprof.MergeOptions{}, fmt.Errorf("backend.ScheduleInterval: %w", err)
+		}
+
+		return pprof.MergeOptions{Mode: pprof.MergeDecay, Decay: pprof.HalfLifeDecay(strategy.HalfLife, interval)}, nil
+	default:
+		return pprof.MergeOptions{Mode: pprof.MergeMode(strategy.Mode), Weight: strategy.Weight}, nil
+	}
+}
+
+// logFetchErrors logs each of the (joined) errors of fetching profiles, with the details of the response if any.
+func logFetchErrors(logger zerolog.Logger, err error) {
+	var joined interface{ Unwrap() []error }
+
+	// A *pprof.FetchError unwraps into multiple errors as well, but it is logged as a whole.
+	if errors.As(err, &joined) {
+		if _, isFetchErr := joined.(*pprof.FetchError); !isFetchErr {
+			for _, err := range joined.Unwrap() {
+				logFetchErrors(logger, err)
+			}
+
+			return
+		}
+	}
+
+	var fetchErr *pprof.FetchError
+
+	if !errors.As(err, &fetchErr) {
+		logger.Warn().Err(err).Msg("Failed to fetch profile")
+
+		return
+	}
+
+	logger.Warn().
+		Str("target", fetchErr.URL).
+		Int("status_code", fetchErr.StatusCode).
+		Str("content_type", fetchErr.ContentType).
+		Str("body_snippet", fetchErr.Snippet).
+		Bool("permanent", fetchErr.Permanent()).
+		AnErr("kind", fetchErr.Kind).
+		AnErr("cause", fetchErr.Cause).
+		Msg("Failed to fetch profile")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"github.com/rs/zerolog/log"
+)
+
+// sampleScheduler adds the samples of the backends to the scheduler while it runs.
+type sampleScheduler struct {
+	// mu guards the scheduler, as the chain of calls adding a job is not safe for concurrent use, e.g. by the runs of
+	// two backends at once.
+	mu        sync.Mutex
+	scheduler *gocron.Scheduler
+}
+
+// scheduleSamples of the window of the job starting now, each of them a job of its own that runs once. None of them
+// holds a slot of the scheduler for the whole window, and the samples are merged by the runs that follow.
+func (s *sampleScheduler) scheduleSamples(ctx context.Context, j *job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logger := log.With().Str("backend_id", j.backend.ID).Logger()
+
+	for _, at := range j.sampler.Schedule(time.Now()) {
+		_, err := s.scheduler.Every(j.backend.Sampling.Window).StartAt(at).LimitRunsTo(1).Do(func() {
+			if err := j.sampler.Sample(ctx); err != nil {
+				logFetchErrors(logger, err)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("scheduler.Do: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/macabu/cpgo/internal/config"
+	"github.com/macabu/cpgo/internal/discovery"
+	"github.com/macabu/cpgo/internal/httpclient"
+	"github.com/macabu/cpgo/internal/pprof"
+	"github.com/macabu/cpgo/internal/retry"
+	"github.com/macabu/cpgo/internal/s3"
+	"github.com/macabu/cpgo/internal/store"
+)
+
+// newSources returns every source of new profiles for the backend of the job, resolving its discovery mechanisms.
+func (j *job) newSources(ctx context.Context) ([]pprof.Source, error) {
+	backend := j.backend
+	retryPolicy := j.retryPolicy
+
+	staticTargets, err := backend.Targets()
+	if err != nil {
+		return nil, fmt.Errorf("backend.Targets: %w", err)
+	}
+
+	var sources []pprof.Source
+
+	for _, target := range staticTargets {
+		if pprof.IsFileURL(target) {
+			sources = append(sources, pprof.NewFileSourceWithOptions(target, pprof.FileSourceOptions{
+				Format: pprof.Format(backend.Format),
+			}))
+		}
+	}
+
+	if j.sampler != nil {
+		// The targets are scraped by the samples instead, see job.scheduleSamples.
+		sources = append(sources, pprof.NewStoreSource(j.samples))
+	} else {
+		scrapeSources, err := j.newScrapeSources(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("newScrapeSources: %w", err)
+		}
+
+		sources = append(sources, scrapeSources...)
+	}
+
+	if j.s3Client != nil {
+		sources = append(sources, newS3Source(j.s3Client, backend, retryPolicy, j.s3Checkpoint))
+	}
+
+	if continuousCfg := backend.ContinuousProfiling; continuousCfg != nil {
+		sources = append(sources, pprof.NewQuerySource(j.querier, pprof.QuerySourceOptions{
+			ProfileType: continuousCfg.ProfileType,
+			Selector:    continuousCfg.Selector,
+			Range:       continuousCfg.Range,
+			Retry:       retryPolicy,
+			Checkpoint:  j.queryCheckpoint,
+		}))
+	}
+
+	if j.inbox != nil {
+		sources = append(sources, pprof.NewStoreSource(j.inbox))
+	}
+
+	return sources, nil
+}
+
+// newScrapeSources returns the sources scraping the HTTP targets of the backend, static and discovered.
+func (j *job) newScrapeSources(ctx context.Context) ([]pprof.Source, error) {
+	backend := j.backend
+
+	staticTargets, err := backend.Targets()
+	if err != nil {
+		return nil, fmt.Errorf("backend.Targets: %w", err)
+	}
+
+	var (
+		sources     []pprof.Source
+		httpTargets []string
+	)
+
+	for _, target := range staticTargets {
+		if !pprof.IsFileURL(target) {
+			httpTargets = append(httpTargets, target)
+		}
+	}
+
+	targets, err := discoverTargets(ctx, backend)
+	if err != nil {
+		return nil, fmt.Errorf("discoverTargets: %w", err)
+	}
+
+	targets = append(discovery.StaticTargets(httpTargets), targets...)
+
+	fetcherOpts := pprof.FetcherOptions{MaxBodySize: backend.HTTP.MaxBodySize, Retry: j.retryPolicy}
+
+	if len(targets) > 0 {
+		fetcher := pprof.NewFetcherWithOptions(j.scrapeClient, fetcherOpts)
+
+		sources = append(sources, pprof.NewHTTPSource(fetcher, targets, backend.Concurrency))
+	}
+
+	if j.kube != nil {
+		discovered, err := j.kube.Targets(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("kube.Targets: %w", err)
+		}
+
+		// Pods are scraped through the API server proxy, hence they need its authenticated client.
+		kubeClient := *j.kube.Client()
+		kubeClient.Timeout = j.scrapeClient.Timeout
+
+		fetcher := pprof.NewFetcherWithOptions(&kubeClient, fetcherOpts)
+
+		sources = append(sources, pprof.NewHTTPSource(fetcher, discovered, backend.Concurrency))
+	}
+
+	return sources, nil
+}
+
+// scrape the HTTP targets of the backend once, see newScrapeSources.
+func (j *job) scrape(ctx context.Context) ([]*profile.Profile, error) {
+	sources, err := j.newScrapeSources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("newScrapeSources: %w", err)
+	}
+
+	return fetchProfiles(ctx, sources)
+}
+
+// newS3Source of the backend, only loading the objects not merged yet when given a checkpoint.
+func newS3Source(
+	client *s3.Client, backend config.Backend, retryPolicy retry.Policy, checkpoint *store.Checkpoint,
+) *pprof.S3Source {
+	s3Cfg := backend.S3
+
+	opts := pprof.S3SourceOptions{
+		Bucket:      s3Cfg.Bucket,
+		Prefix:      s3Cfg.Prefix,
+		MaxBodySize: backend.HTTP.MaxBodySize,
+		Retry:       retryPolicy,
+		Format:      pprof.Format(backend.Format),
+		Checkpoint:  checkpoint,
+	}
+
+	return pprof.NewS3Source(client, opts)
+}
+
+// newS3Client of the backend, reading the credentials from the environment.
+func newS3Client(backend config.Backend) (*s3.Client, error) {
+	s3Cfg := backend.S3
+
+	// The scrape credentials and headers are not sent, as they would override the request signature.
+	client, err := httpclient.New(httpclient.Options{
+		TLS: httpclient.TLS{
+			CAFile:             backend.Auth.TLS.CAFile,
+			CertFile:           backend.Auth.TLS.CertFile,
+			KeyFile:            backend.Auth.TLS.KeyFile,
+			ServerName:         backend.HTTP.TLSServerName,
+			InsecureSkipVerify: backend.HTTP.InsecureSkipVerify,
+		},
+		Timeout:        backend.HTTP.Timeout,
+		ConnectTimeout: backend.HTTP.ConnectTimeout,
+		ProxyURL:       backend.HTTP.ProxyURL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("httpclient.New: %w", err)
+	}
+
+	return s3.NewClient(client, s3.Options{
+		Endpoint:  s3Cfg.Endpoint,
+		Region:    s3Cfg.Region,
+		PathStyle: s3Cfg.PathStyle,
+		Credentials: s3.Credentials{
+			AccessKeyID:     os.Getenv(envOrDefault(s3Cfg.AccessKeyIDEnv, "AWS_ACCESS_KEY_ID")),
+			SecretAccessKey: os.Getenv(envOrDefault(s3Cfg.SecretAccessKeyEnv, "AWS_SECRET_ACCESS_KEY")),
+			SessionToken:    os.Getenv(envOrDefault(s3Cfg.SessionTokenEnv, "AWS_SESSION_TOKEN")),
+		},
+	}), nil
+}
+
+// envOrDefault returns the name of the environment variable, or the fallback if not set.
+func envOrDefault(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+
+	return name
+}
+
+// discoverTargets returns the targets found through the DNS and file_sd discovery of the backend.
+func discoverTargets(ctx context.Context, backend config.Backend) ([]discovery.Target, error) {
+	var targets []discovery.Target
+
+	if dnsCfg := backend.Discovery.DNS; dnsCfg != nil {
+		dns := discovery.NewDNS(net.DefaultResolver, discovery.DNSOptions{
+			Name:       dnsCfg.Name,
+			RecordType: dnsCfg.RecordType,
+			Port:       dnsCfg.Port,
+			Scheme:     dnsCfg.Scheme,
+			Path:       dnsCfg.Path,
+		})
+
+		discovered, err := dns.Targets(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("dns.Targets: %w", err)
+		}
+
+		targets = append(targets, discovered...)
+	}
+
+	if fileSDCfg := backend.Discovery.FileSD; fileSDCfg != nil {
+		fileSD := discovery.NewFileSD(discovery.FileSDOptions{
+			Files:  fileSDCfg.Files,
+			Scheme: fileSDCfg.Scheme,
+			Path:   fileSDCfg.Path,
+		})
+
+		discovered, err := fileSD.Targets()
+		if err != nil {
+			return nil, fmt.Errorf("fileSD.Targets: %w", err)
+		}
+
+		targets = append(targets, discovered...)
+	}
+
+	return targets, nil
+}
+
+// newScrapeClient builds the HTTP client that authenticates against the pprof endpoints of the backend.
+func newScrapeClient(backend config.Backend) (*http.Client, error) {
+	opts := httpclient.Options{
+		BearerTokenFile: backend.Auth.BearerTokenFile,
+		BearerTokenEnv:  backend.Auth.BearerTokenEnv,
+		TLS: httpclient.TLS{
+			CAFile:             backend.Auth.TLS.CAFile,
+			CertFile:           backend.Auth.TLS.CertFile,
+			KeyFile:            backend.Auth.TLS.KeyFile,
+			ServerName:         backend.HTTP.TLSServerName,
+			InsecureSkipVerify: backend.HTTP.InsecureSkipVerify,
+		},
+		Headers:        backend.Auth.Headers,
+		Timeout:        backend.HTTP.Timeout,
+		ConnectTimeout: backend.HTTP.ConnectTimeout,
+		ProxyURL:       backend.HTTP.ProxyURL,
+	}
+
+	if basicAuth := backend.Auth.BasicAuth; basicAuth != nil {
+		opts.BasicAuth = &httpclient.BasicAuth{
+			Username:     basicAuth.Username,
+			PasswordFile: basicAuth.PasswordFile,
+			PasswordEnv:  basicAuth.PasswordEnv,
+		}
+	}
+
+	client, err := httpclient.New(opts)
+	if err != nil {
+		return nil, fmt.Errorf("httpclient.New: %w", err)
+	}
+
+	return client, nil
+}
+
+// fetchProfiles from every source. The fetched profiles are returned alongside the joined errors.
+func fetchProfiles(ctx context.Context, sources []pprof.Source) ([]*profile.Profile, error) {
+	var (
+		profiles []*profile.Profile
+		errs     []error
+	)
+
+	for _, source := range sources {
+		fetched, err := source.Profiles(ctx)
+
+		profiles = append(profiles, fetched...)
+		errs = append(errs, err)
+	}
+
+	return profiles, errors.Join(errs...)
+}
+
+// newKubernetes discovery of the pods of the backend.
+func newKubernetes(backend config.Backend) (*discovery.Kubernetes, error) {
+	kubeCfg := backend.Discovery.Kubernetes
+
+	kube, err := discovery.NewKubernetes(discovery.KubernetesOptions{
+		Kubeconfig:    kubeCfg.Kubeconfig,
+		Context:       kubeCfg.Context,
+		Namespace:     kubeCfg.Namespace,
+		LabelSelector: kubeCfg.LabelSelector,
+		Port:          kubeCfg.Port,
+		Scheme:        kubeCfg.Scheme,
+		Path:          kubeCfg.Path,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("discovery.NewKubernetes: %w", err)
+	}
+
+	return kube, nil
+}
@@ -1,10 +1,16 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"text/template"
+	"time"
 
+	"github.com/robfig/cron/v3"
 	"gopkg.in/yaml.v3"
+
+	"github.com/macabu/cpgo/internal/retry"
 )
 
 type OpenPR struct {
@@ -13,14 +19,375 @@ type OpenPR struct {
 	TargetBranch string `yaml:"target_branch"`
 }
 
+type DNSDiscovery struct {
+	Name       string `yaml:"name"`
+	RecordType string `yaml:"record_type"`
+	Port       int    `yaml:"port"`
+	Scheme     string `yaml:"scheme"`
+	Path       string `yaml:"path"`
+}
+
+type KubernetesDiscovery struct {
+	Kubeconfig    string `yaml:"kubeconfig"`
+	Context       string `yaml:"context"`
+	Namespace     string `yaml:"namespace"`
+	LabelSelector string `yaml:"label_selector"`
+	Port          string `yaml:"port"`
+	Scheme        string `yaml:"scheme"`
+	Path          string `yaml:"path"`
+}
+
+type FileSDDiscovery struct {
+	Files  []string `yaml:"files"`
+	Scheme string   `yaml:"scheme"`
+	Path   string   `yaml:"path"`
+}
+
+type Discovery struct {
+	DNS        *DNSDiscovery        `yaml:"dns"`
+	Kubernetes *KubernetesDiscovery `yaml:"kubernetes"`
+	FileSD     *FileSDDiscovery     `yaml:"file_sd"`
+}
+
+// Enabled reports whether any discovery mechanism is configured.
+func (d Discovery) Enabled() bool {
+	return d.DNS != nil || d.Kubernetes != nil || d.FileSD != nil
+}
+
+type BasicAuth struct {
+	Username     string `yaml:"username"`
+	PasswordFile string `yaml:"password_file"`
+	PasswordEnv  string `yaml:"password_env"`
+}
+
+type TLS struct {
+	CAFile   string `yaml:"ca_file"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+type Auth struct {
+	BearerTokenFile string            `yaml:"bearer_token_file"`
+	BearerTokenEnv  string            `yaml:"bearer_token_env"`
+	BasicAuth       *BasicAuth        `yaml:"basic_auth"`
+	TLS             TLS               `yaml:"tls"`
+	Headers         map[string]string `yaml:"headers"`
+}
+
+type HTTP struct {
+	Timeout            time.Duration `yaml:"timeout"`
+	ConnectTimeout     time.Duration `yaml:"connect_timeout"`
+	ProxyURL           string        `yaml:"proxy_url"`
+	TLSServerName      string        `yaml:"tls_server_name"`
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify"`
+	MaxBodySize        int64         `yaml:"max_body_size"`
+}
+
+// Retry settings that are unset are inherited, see Config.RetryPolicy.
+type Retry struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	Multiplier     float64       `yaml:"multiplier"`
+	// Jitter is a pointer, as zero disables it rather than leaving it unset.
+	Jitter *float64 `yaml:"jitter"`
+}
+
+type S3 struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	PathStyle bool   `yaml:"path_style"`
+	// The credentials are read from these environment variables, defaulting to the AWS_* ones.
+	AccessKeyIDEnv     string `yaml:"access_key_id_env"`
+	SecretAccessKeyEnv string `yaml:"secret_access_key_env"`
+	SessionTokenEnv    string `yaml:"session_token_env"`
+	// NewerThanLastRun only loads the objects not merged by a previous run, tracked under the data dir across restarts.
+	NewerThanLastRun bool `yaml:"newer_than_last_run"`
+}
+
+type ContinuousProfiling struct {
+	// Kind of the server, either pyroscope or parca.
+	Kind        string `yaml:"kind"`
+	URL         string `yaml:"url"`
+	Selector    string `yaml:"selector"`
+	ProfileType string `yaml:"profile_type"`
+	// Range until now of the first query, the following ones start from the end of the last one unless replacing.
+	Range time.Duration `yaml:"range"`
+}
+
+// MergeModeRetention rebuilds the PGO file from a sliding window of the raw profiles, kept in the data dir.
+const MergeModeRetention = "retention"
+
+type MergeStrategy struct {
+	// Mode is one of accumulate (default), replace, weighted, decay or retention.
+	Mode string `yaml:"mode"`
+	// Weight of the new profiles in weighted mode, above 0 and up to 1. The existing profile has the rest.
+	Weight float64 `yaml:"weight"`
+	// Factor multiplies the values of the existing profile before every merge in decay mode, between 0 and 1 (exclusive).
+	Factor float64 `yaml:"factor"`
+	// HalfLife after which the weight of a merged profile is halved in decay mode, instead of the factor, which is then
+	// derived from the schedule.
+	HalfLife time.Duration `yaml:"half_life"`
+	// MaxProfiles kept in the window in retention mode, unbounded when zero.
+	MaxProfiles int `yaml:"max_profiles"`
+	// MaxAge of the profiles kept in the window in retention mode, unbounded when zero.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// validateFormats of the profiles loaded and of the PGO file, which would only fail once every profile was fetched.
+func (b Backend) validateFormats() error {
+	switch b.Format {
+	case "", "pprof", "folded", "perf_script", "preprofile":
+	default:
+		return fmt.Errorf("format %v: %w", b.Format, ErrUnsupportedFormat)
+	}
+
+	switch b.Output.Format {
+	case "", "pprof", "preprofile":
+	default:
+		return fmt.Errorf("output format %v: %w", b.Output.Format, ErrUnsupportedFormat)
+	}
+
+	return nil
+}
+
+// validateSampling of the backend, whose windows must not overlap as every run starts one, and schedules its samples.
+func (b Backend) validateSampling() error {
+	if b.Sampling.Window <= 0 {
+		return ErrNoSamplingWindow
+	}
+
+	interval, err := b.ScheduleInterval()
+	if err != nil {
+		return err
+	}
+
+	if interval < b.Sampling.Window {
+		return fmt.Errorf("every %v, window of %v: %w", interval, b.Sampling.Window, ErrSamplingWindowTooLong)
+	}
+
+	return nil
+}
+
+// validate the settings of the mode, so that a misconfigured backend fails at startup rather than on its runs.
+func (m MergeStrategy) validate() error {
+	switch m.Mode {
+	case "", "accumulate", "replace", MergeModeRetention:
+	case "weighted":
+		// Zero is not allowed either, as it is the default when unset and would ignore the new profiles.
+		if m.Weight <= 0 || m.Weight > 1 {
+			return fmt.Errorf("%v: %w", m.Weight, ErrInvalidWeight)
+		}
+	case "decay":
+		// Exactly one of them, as it would be ambiguous which one applies otherwise.
+		validFactor := m.Factor > 0 && m.Factor < 1 && m.HalfLife == 0
+		validHalfLife := m.Factor == 0 && m.HalfLife > 0
+
+		if !validFactor && !validHalfLife {
+			return fmt.Errorf("factor %v, half-life %v: %w", m.Factor, m.HalfLife, ErrInvalidDecay)
+		}
+	default:
+		return fmt.Errorf("%v: %w", m.Mode, ErrUnsupportedMergeMode)
+	}
+
+	return nil
+}
+
+type Prune struct {
+	// Threshold drops the coldest samples and call edges that together make up less than this share of the total,
+	// from 0 (inclusive) to 1 (exclusive).
+	Threshold float64 `yaml:"threshold"`
+	// MaxSize caps the PGO file to this many bytes, keeping the hottest samples.
+	MaxSize int64 `yaml:"max_size"`
+}
+
+// validate the threshold, so that it fails at startup rather than when merging.
+func (p Prune) validate() error {
+	if p.Threshold < 0 || p.Threshold >= 1 {
+		return fmt.Errorf("%v: %w", p.Threshold, ErrInvalidThreshold)
+	}
+
+	return nil
+}
+
+// Filter is made of regular expressions over the function names and files, as in the pprof CLI, and of rules over the
+// labels of the samples, e.g. set with pprof.Labels.
+type Filter struct {
+	Focus     string `yaml:"focus"`
+	Ignore    string `yaml:"ignore"`
+	Hide      string `yaml:"hide"`
+	PruneFrom string `yaml:"prune_from"`
+	// KeepLabels keeps only the samples that have, for every key, one of the values.
+	KeepLabels map[string][]string `yaml:"keep_labels"`
+	// DropLabels drops the samples that have, for any key, one of the values.
+	DropLabels map[string][]string `yaml:"drop_labels"`
+	// LabelWeights multiply the samples with all the labels of the first matching one.
+	LabelWeights []LabelWeight `yaml:"label_weights"`
+}
+
+type LabelWeight struct {
+	Labels map[string]string `yaml:"labels"`
+	Weight float64           `yaml:"weight"`
+}
+
+type Output struct {
+	// Minimal strips what the Go compiler does not use from the PGO file, e.g. labels, mappings and addresses.
+	Minimal bool `yaml:"minimal"`
+	// Format of the PGO file: pprof (default), or preprofile for the preprocessed format of Go 1.23.
+	Format string `yaml:"format"`
+	// CompanionFile, if set, is also committed with the profile in the preprocessed format.
+	CompanionFile string `yaml:"companion_file"`
+}
+
+type Sampling struct {
+	// Samples is how many profiles are scraped during the window, at random moments.
+	Samples int `yaml:"samples"`
+	// Window of time the samples are spread across, starting on every tick of the schedule.
+	Window time.Duration `yaml:"window"`
+}
+
+type Push struct {
+	// The bearer token expected from the clients, read on every upload from a file or an environment variable.
+	TokenFile string `yaml:"token_file"`
+	TokenEnv  string `yaml:"token_env"`
+}
+
 type Backend struct {
-	URL      string `yaml:"url"`
+	// ID of the backend, used in the path of the ingestion endpoint and for its local data.
+	ID          string    `yaml:"id"`
+	URL         string    `yaml:"url"`
+	URLs        []string  `yaml:"urls"`
+	URLTemplate string    `yaml:"url_template"`
+	Replicas    int       `yaml:"replicas"`
+	Concurrency int       `yaml:"concurrency"`
+	Discovery   Discovery `yaml:"discovery"`
+	S3          *S3       `yaml:"s3"`
+	// Format of the profiles loaded from files and S3: pprof (default), folded, perf_script or preprofile.
+	Format string `yaml:"format"`
+	// ContinuousProfiling pulls the profile aggregated by a continuous profiling server.
+	ContinuousProfiling *ContinuousProfiling `yaml:"continuous_profiling"`
+	// Sampling scrapes the targets several times across a window, instead of once per run.
+	Sampling *Sampling `yaml:"sampling"`
+	// Push accepts the profiles uploaded to the ingestion server, merged on the schedule of the backend.
+	Push     *Push  `yaml:"push"`
+	Auth     Auth   `yaml:"auth"`
+	HTTP     HTTP   `yaml:"http"`
+	Retry    *Retry `yaml:"retry"`
 	Schedule string `yaml:"schedule"`
-	OpenPR   OpenPR `yaml:"open_pull_request"`
+	// Filter is applied to each new profile before merging.
+	Filter Filter `yaml:"filter"`
+	// MergeStrategy decides how the new profiles are merged with the existing PGO file.
+	MergeStrategy MergeStrategy `yaml:"merge_strategy"`
+	// Prune drops the insignificant samples after merging, so that the PGO file does not keep growing.
+	Prune  Prune  `yaml:"prune"`
+	Output Output `yaml:"output"`
+	OpenPR OpenPR `yaml:"open_pull_request"`
+}
+
+// Replica is the data available when rendering Backend.URLTemplate.
+type Replica struct {
+	Index int
+}
+
+// ScheduleInterval returns the interval between the next two runs of Backend.Schedule, which is constant for most
+// schedules.
+func (b Backend) ScheduleInterval() (time.Duration, error) {
+	schedule, err := cron.ParseStandard(b.Schedule)
+	if err != nil {
+		return 0, fmt.Errorf("cron.ParseStandard: %w", err)
+	}
+
+	next := schedule.Next(time.Now())
+
+	return schedule.Next(next).Sub(next), nil
+}
+
+// Targets returns every static URL that should be scraped for the backend, rendering Backend.URLTemplate once per replica.
+// Targets found through Backend.Discovery are only known at run time, and therefore not included.
+// Neither are Backend.S3, Backend.ContinuousProfiling and Backend.Push, which are not scraped.
+func (b Backend) Targets() ([]string, error) {
+	targets := make([]string, 0, 1+len(b.URLs)+b.Replicas)
+
+	if b.URL != "" {
+		targets = append(targets, b.URL)
+	}
+
+	targets = append(targets, b.URLs...)
+
+	if b.URLTemplate != "" {
+		tmpl, err := template.New("url_template").Option("missingkey=error").Parse(b.URLTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("template.Parse: %w", err)
+		}
+
+		for i := 0; i < b.Replicas; i++ {
+			var buf bytes.Buffer
+
+			if err := tmpl.Execute(&buf, Replica{Index: i}); err != nil {
+				return nil, fmt.Errorf("template.Execute: %w", err)
+			}
+
+			targets = append(targets, buf.String())
+		}
+	}
+
+	if len(targets) == 0 && !b.Discovery.Enabled() && b.S3 == nil && b.ContinuousProfiling == nil && b.Push == nil {
+		return nil, ErrNoTargets
+	}
+
+	return targets, nil
+}
+
+// DefaultDataDir is relative to the working directory.
+const DefaultDataDir = "data"
+
+type Ingestion struct {
+	MaxBodySize int64 `yaml:"max_body_size"`
 }
 
 type Config struct {
-	Backends []Backend `yaml:"backends"`
+	// DataDir keeps the local data of the backends, e.g. the pushed profiles. Defaults to DefaultDataDir.
+	DataDir string `yaml:"data_dir"`
+	// Retry is the default for all backends, unless they set their own.
+	Retry     *Retry    `yaml:"retry"`
+	Ingestion Ingestion `yaml:"ingestion"`
+	Backends  []Backend `yaml:"backends"`
+}
+
+// RetryPolicy of the backend. Each setting of its retry block overrides the global one, which overrides
+// retry.DefaultPolicy, so that a block only has to set what differs.
+func (c Config) RetryPolicy(backend Backend) retry.Policy {
+	policy := retry.DefaultPolicy
+
+	for _, retryCfg := range []*Retry{c.Retry, backend.Retry} {
+		if retryCfg == nil {
+			continue
+		}
+
+		if retryCfg.MaxAttempts > 0 {
+			policy.MaxAttempts = retryCfg.MaxAttempts
+		}
+
+		if retryCfg.InitialBackoff > 0 {
+			policy.InitialBackoff = retryCfg.InitialBackoff
+		}
+
+		if retryCfg.MaxBackoff > 0 {
+			policy.MaxBackoff = retryCfg.MaxBackoff
+		}
+
+		if retryCfg.Multiplier > 0 {
+			policy.Multiplier = retryCfg.Multiplier
+		}
+
+		if retryCfg.Jitter != nil {
+			policy.Jitter = *retryCfg.Jitter
+		}
+	}
+
+	return policy
 }
 
 // Parse a yaml given file into a *config.Config struct.
@@ -38,5 +405,47 @@ func Parse(path string) (*Config, error) {
 		return nil, fmt.Errorf("yaml.NewDecoder.Decode: %w", err)
 	}
 
+	if config.DataDir == "" {
+		config.DataDir = DefaultDataDir
+	}
+
+	ids := make(map[string]struct{}, len(config.Backends))
+
+	for _, backend := range config.Backends {
+		if backend.ID != "" {
+			if _, ok := ids[backend.ID]; ok {
+				return nil, fmt.Errorf("%w: %v", ErrDuplicateBackendID, backend.ID)
+			}
+
+			ids[backend.ID] = struct{}{}
+		}
+
+		needsID := backend.Push != nil || backend.Sampling != nil || backend.MergeStrategy.Mode == MergeModeRetention ||
+			backend.S3 != nil && backend.S3.NewerThanLastRun ||
+			backend.ContinuousProfiling != nil && backend.MergeStrategy.Mode != "replace"
+
+		if needsID && backend.ID == "" {
+			return nil, ErrNoBackendID
+		}
+
+		if err := backend.MergeStrategy.validate(); err != nil {
+			return nil, fmt.Errorf("merge_strategy: %w", err)
+		}
+
+		if err := backend.Prune.validate(); err != nil {
+			return nil, fmt.Errorf("prune: %w", err)
+		}
+
+		if err := backend.validateFormats(); err != nil {
+			return nil, err
+		}
+
+		if backend.Sampling != nil {
+			if err := backend.validateSampling(); err != nil {
+				return nil, fmt.Errorf("sampling: %w", err)
+			}
+		}
+	}
+
 	return &config, nil
 }
@@ -3,16 +3,24 @@ package config_test
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/macabu/cpgo/internal/config"
+	"github.com/macabu/cpgo/internal/retry"
 )
 
 const validConfig = `---
 backends:
 - url: http://localhost:6060/debug/pprof/profile?seconds=30
   schedule: '* * * * *'
+  discovery:
+    kubernetes:
+      namespace: default
+      label_selector: app=example
+      port: 6060
+      path: /debug/pprof/profile?seconds=30
   open_pull_request:
     repository: http://github.com/example/example
     target_file: default.pgo
@@ -38,6 +46,203 @@ func TestParse(t *testing.T) {
 		cfg, err := config.Parse(file.Name())
 		require.NoError(t, err)
 		require.NotNil(t, cfg)
+		require.Len(t, cfg.Backends, 1)
+		require.NotNil(t, cfg.Backends[0].Discovery.Kubernetes)
+		require.Equal(t, "6060", cfg.Backends[0].Discovery.Kubernetes.Port)
+		require.Equal(t, config.DefaultDataDir, cfg.DataDir)
+	})
+
+	t.Run("when a backend accepts pushed profiles without an id, return an error", func(t *testing.T) {
+		t.Parallel()
+
+		file, err := os.CreateTemp(t.TempDir(), "no-id")
+		require.NoError(t, err)
+
+		_, err = file.Write([]byte("backends:\n- push:\n    token_env: CHECKOUT_PUSH_TOKEN\n"))
+		require.NoError(t, err)
+
+		cfg, err := config.Parse(file.Name())
+		require.ErrorIs(t, err, config.ErrNoBackendID)
+		require.Nil(t, cfg)
+	})
+
+	t.Run("when a backend tracks the merged objects of its bucket without an id, return an error", func(t *testing.T) {
+		t.Parallel()
+
+		file, err := os.CreateTemp(t.TempDir(), "no-id")
+		require.NoError(t, err)
+
+		_, err = file.Write([]byte("backends:\n- s3:\n    bucket: profiles\n    newer_than_last_run: true\n"))
+		require.NoError(t, err)
+
+		cfg, err := config.Parse(file.Name())
+		require.ErrorIs(t, err, config.ErrNoBackendID)
+		require.Nil(t, cfg)
+	})
+
+	t.Run("when two backends have the same id, return an error", func(t *testing.T) {
+		t.Parallel()
+
+		file, err := os.CreateTemp(t.TempDir(), "duplicate-id")
+		require.NoError(t, err)
+
+		_, err = file.Write([]byte("backends:\n- id: checkout\n  url: http://a\n- id: checkout\n  url: http://b\n"))
+		require.NoError(t, err)
+
+		cfg, err := config.Parse(file.Name())
+		require.ErrorIs(t, err, config.ErrDuplicateBackendID)
+		require.Nil(t, cfg)
+	})
+
+	t.Run("when a backend samples its targets without a window, return an error", func(t *testing.T) {
+		t.Parallel()
+
+		file, err := os.CreateTemp(t.TempDir(), "no-window")
+		require.NoError(t, err)
+
+		_, err = file.Write([]byte("backends:\n- id: checkout\n  url: http://a\n  sampling:\n    samples: 12\n"))
+		require.NoError(t, err)
+
+		cfg, err := config.Parse(file.Name())
+		require.ErrorIs(t, err, config.ErrNoSamplingWindow)
+		require.Nil(t, cfg)
+	})
+
+	t.Run("when a backend decays without exactly one valid factor or half-life, return an error", func(t *testing.T) {
+		t.Parallel()
+
+		strategies := []string{
+			"{mode: decay}",
+			"{mode: decay, factor: 1}",
+			"{mode: decay, factor: -0.5}",
+			"{mode: decay, half_life: -1h}",
+			"{mode: decay, factor: 0.9, half_life: 168h}",
+		}
+
+		for _, strategy := range strategies {
+			file, err := os.CreateTemp(t.TempDir(), "invalid-decay")
+			require.NoError(t, err)
+
+			_, err = file.Write([]byte("backends:\n- url: http://a\n  merge_strategy: " + strategy + "\n"))
+			require.NoError(t, err)
+
+			cfg, err := config.Parse(file.Name())
+			require.ErrorIs(t, err, config.ErrInvalidDecay, strategy)
+			require.Nil(t, cfg)
+		}
+	})
+
+	t.Run("when a backend has an invalid merge mode or weight, return an error", func(t *testing.T) {
+		t.Parallel()
+
+		testcases := []struct {
+			strategy    string
+			expectedErr error
+		}{
+			{strategy: "{mode: average}", expectedErr: config.ErrUnsupportedMergeMode},
+			{strategy: "{mode: weighted}", expectedErr: config.ErrInvalidWeight},
+			{strategy: "{mode: weighted, weight: 1.5}", expectedErr: config.ErrInvalidWeight},
+		}
+
+		for _, tt := range testcases {
+			file, err := os.CreateTemp(t.TempDir(), "invalid-strategy")
+			require.NoError(t, err)
+
+			_, err = file.Write([]byte("backends:\n- url: http://a\n  merge_strategy: " + tt.strategy + "\n"))
+			require.NoError(t, err)
+
+			cfg, err := config.Parse(file.Name())
+			require.ErrorIs(t, err, tt.expectedErr, tt.strategy)
+			require.Nil(t, cfg)
+		}
+	})
+
+	t.Run("when the sampling window is longer than the interval of the schedule, return an error", func(t *testing.T) {
+		t.Parallel()
+
+		testcases := []struct {
+			schedule    string
+			expectedErr error
+		}{
+			{schedule: "'* * * * *'", expectedErr: config.ErrSamplingWindowTooLong},
+			{schedule: "'0 */3 * * *'", expectedErr: config.ErrSamplingWindowTooLong},
+			{schedule: "'0 */6 * * *'"},
+		}
+
+		for _, tt := range testcases {
+			file, err := os.CreateTemp(t.TempDir(), "sampling")
+			require.NoError(t, err)
+
+			_, err = file.Write([]byte("backends:\n- id: checkout\n  url: http://a\n  schedule: " + tt.schedule +
+				"\n  sampling:\n    samples: 12\n    window: 6h\n"))
+			require.NoError(t, err)
+
+			_, err = config.Parse(file.Name())
+			require.ErrorIs(t, err, tt.expectedErr, tt.schedule)
+		}
+	})
+
+	t.Run("when a backend queries what is new on its continuous profiling server without an id, return an error", func(t *testing.T) {
+		t.Parallel()
+
+		testcases := []struct {
+			strategy    string
+			expectedErr error
+		}{
+			{strategy: "{}", expectedErr: config.ErrNoBackendID},
+			{strategy: "{mode: accumulate}", expectedErr: config.ErrNoBackendID},
+			// Every run queries the whole range instead.
+			{strategy: "{mode: replace}"},
+		}
+
+		for _, tt := range testcases {
+			file, err := os.CreateTemp(t.TempDir(), "no-id")
+			require.NoError(t, err)
+
+			_, err = file.Write([]byte("backends:\n- continuous_profiling:\n    url: http://pyroscope:4040\n" +
+				"  merge_strategy: " + tt.strategy + "\n"))
+			require.NoError(t, err)
+
+			_, err = config.Parse(file.Name())
+			require.ErrorIs(t, err, tt.expectedErr, tt.strategy)
+		}
+	})
+
+	t.Run("when a backend has an unsupported format, return an error", func(t *testing.T) {
+		t.Parallel()
+
+		formats := []string{
+			"format: jfr",
+			"format: preprofile\n  output:\n    format: folded",
+		}
+
+		for _, format := range formats {
+			file, err := os.CreateTemp(t.TempDir(), "invalid-format")
+			require.NoError(t, err)
+
+			_, err = file.Write([]byte("backends:\n- url: http://a\n  " + format + "\n"))
+			require.NoError(t, err)
+
+			cfg, err := config.Parse(file.Name())
+			require.ErrorIs(t, err, config.ErrUnsupportedFormat, format)
+			require.Nil(t, cfg)
+		}
+	})
+
+	t.Run("when a backend has a prune threshold out of range, return an error", func(t *testing.T) {
+		t.Parallel()
+
+		for _, threshold := range []string{"-0.1", "1", "5"} {
+			file, err := os.CreateTemp(t.TempDir(), "invalid-threshold")
+			require.NoError(t, err)
+
+			_, err = file.Write([]byte("backends:\n- url: http://a\n  prune:\n    threshold: " + threshold + "\n"))
+			require.NoError(t, err)
+
+			cfg, err := config.Parse(file.Name())
+			require.ErrorIs(t, err, config.ErrInvalidThreshold, threshold)
+			require.Nil(t, cfg)
+		}
 	})
 
 	t.Run("when the file does not exist, return an error", func(t *testing.T) {
@@ -66,3 +271,150 @@ func TestParse(t *testing.T) {
 		require.Nil(t, cfg)
 	})
 }
+
+func TestBackendTargets(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		name            string
+		backend         config.Backend
+		expectedTargets []string
+		expectedErr     error
+	}{
+		{
+			name:            "given a single url, then it is the only target",
+			backend:         config.Backend{URL: "http://localhost:6060/debug/pprof/profile"},
+			expectedTargets: []string{"http://localhost:6060/debug/pprof/profile"},
+		},
+		{
+			name: "given a url and a list of urls, then all of them are targets",
+			backend: config.Backend{
+				URL:  "http://a:6060/debug/pprof/profile",
+				URLs: []string{"http://b:6060/debug/pprof/profile", "http://c:6060/debug/pprof/profile"},
+			},
+			expectedTargets: []string{
+				"http://a:6060/debug/pprof/profile",
+				"http://b:6060/debug/pprof/profile",
+				"http://c:6060/debug/pprof/profile",
+			},
+		},
+		{
+			name: "given a url template, then it is rendered once per replica",
+			backend: config.Backend{
+				URLTemplate: "http://checkout-{{ .Index }}.checkout:6060/debug/pprof/profile",
+				Replicas:    2,
+			},
+			expectedTargets: []string{
+				"http://checkout-0.checkout:6060/debug/pprof/profile",
+				"http://checkout-1.checkout:6060/debug/pprof/profile",
+			},
+		},
+		{
+			name: "given only discovery, then there are no static targets",
+			backend: config.Backend{
+				Discovery: config.Discovery{DNS: &config.DNSDiscovery{Name: "checkout.default.svc"}},
+			},
+			expectedTargets: []string{},
+		},
+		{
+			name: "given only an s3 bucket, then there are no static targets",
+			backend: config.Backend{
+				S3: &config.S3{Bucket: "profiles"},
+			},
+			expectedTargets: []string{},
+		},
+		{
+			name: "given only a continuous profiling server, then there are no static targets",
+			backend: config.Backend{
+				ContinuousProfiling: &config.ContinuousProfiling{Kind: "pyroscope", URL: "http://pyroscope:4040"},
+			},
+			expectedTargets: []string{},
+		},
+		{
+			name: "given only pushed profiles, then there are no static targets",
+			backend: config.Backend{
+				ID:   "checkout",
+				Push: &config.Push{TokenEnv: "CHECKOUT_PUSH_TOKEN"},
+			},
+			expectedTargets: []string{},
+		},
+		{
+			name:        "when there are no targets configured, an error is returned",
+			backend:     config.Backend{},
+			expectedErr: config.ErrNoTargets,
+		},
+	}
+
+	for _, tt := range testcases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			actualTargets, err := tt.backend.Targets()
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.EqualValues(t, tt.expectedTargets, actualTargets)
+		})
+	}
+
+	t.Run("when the url template is invalid, an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		backend := config.Backend{URLTemplate: "http://checkout-{{ .Missing }}", Replicas: 1}
+
+		actualTargets, err := backend.Targets()
+		require.Error(t, err)
+		require.Nil(t, actualTargets)
+	})
+}
+
+func TestConfigRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	noJitter := 0.0
+
+	cfg := config.Config{
+		Retry: &config.Retry{MaxAttempts: 5, MaxBackoff: time.Minute},
+	}
+
+	testcases := []struct {
+		name     string
+		backend  config.Backend
+		expected retry.Policy
+	}{
+		{
+			name:    "given no retry block, then the global one applies over the default policy",
+			backend: config.Backend{},
+			expected: retry.Policy{
+				MaxAttempts:    5,
+				InitialBackoff: retry.DefaultPolicy.InitialBackoff,
+				MaxBackoff:     time.Minute,
+				Multiplier:     retry.DefaultPolicy.Multiplier,
+				Jitter:         retry.DefaultPolicy.Jitter,
+			},
+		},
+		{
+			name:    "given a partial retry block, then only its settings override the global ones",
+			backend: config.Backend{Retry: &config.Retry{InitialBackoff: 2 * time.Second, Jitter: &noJitter}},
+			expected: retry.Policy{
+				MaxAttempts:    5,
+				InitialBackoff: 2 * time.Second,
+				MaxBackoff:     time.Minute,
+				Multiplier:     retry.DefaultPolicy.Multiplier,
+				Jitter:         0,
+			},
+		},
+	}
+
+	for _, tt := range testcases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.expected, cfg.RetryPolicy(tt.backend))
+		})
+	}
+
+	require.Equal(t, retry.DefaultPolicy, config.Config{}.RetryPolicy(config.Backend{}))
+}
@@ -0,0 +1,16 @@
+package config
+
+import "errors"
+
+var (
+	ErrNoTargets             = errors.New("backend has no url, urls or url_template with replicas configured")
+	ErrNoBackendID           = errors.New("backend has no id, which is needed for its local data, e.g. pushed or retained profiles")
+	ErrDuplicateBackendID    = errors.New("backend id is used by another backend, which would share its local data")
+	ErrNoSamplingWindow      = errors.New("backend samples its targets without a window to spread the samples across")
+	ErrSamplingWindowTooLong = errors.New("sampling window is longer than the interval of the schedule, which starts one every run")
+	ErrUnsupportedMergeMode  = errors.New("unsupported merge mode")
+	ErrUnsupportedFormat     = errors.New("unsupported profile format")
+	ErrInvalidWeight         = errors.New("weight must be above 0 and up to 1")
+	ErrInvalidDecay          = errors.New("decay needs either a factor between 0 and 1 (exclusive) or a positive half-life")
+	ErrInvalidThreshold      = errors.New("prune threshold must be at least 0 and below 1")
+)
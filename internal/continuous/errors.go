@@ -0,0 +1,30 @@
+package continuous
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/macabu/cpgo/internal/retry"
+)
+
+var (
+	ErrUnsupportedKind = errors.New("unsupported continuous profiling server kind")
+	ErrEmptyProfile    = errors.New("no profiles match the query")
+)
+
+// Error is returned for unsuccessful responses of the server.
+type Error struct {
+	URL        string
+	StatusCode int
+	// Snippet of the beginning of the body, which usually holds the error message.
+	Snippet string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("unexpected status code %v from %v: %v", e.StatusCode, e.URL, e.Snippet)
+}
+
+// Permanent reports whether the request would fail again, e.g. due to an invalid query or missing permissions.
+func (e *Error) Permanent() bool {
+	return !retry.IsTransientStatus(e.StatusCode)
+}
@@ -0,0 +1,71 @@
+package continuous
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ParcaCPUProfileType is the CPU profile type collected by the Parca Agent.
+const ParcaCPUProfileType = "parca_agent_cpu:samples:count:cpu:nanoseconds:delta"
+
+// Parca queries a Parca server through the HTTP gateway of its QueryService.
+// Reference: https://buf.build/parca-dev/parca/docs/main:parca.query.v1alpha1
+type Parca struct {
+	client *http.Client
+	opts   Options
+}
+
+func NewParca(client *http.Client, opts Options) *Parca {
+	return &Parca{
+		client: client,
+		opts:   opts,
+	}
+}
+
+type parcaQueryResponse struct {
+	// Pprof is base64 encoded in JSON, which encoding/json decodes into the bytes.
+	Pprof []byte `json:"pprof"`
+}
+
+// MergedProfile calls the Query method in merge mode, with the report in the pprof format.
+func (p Parca) MergedProfile(ctx context.Context, query Query) ([]byte, error) {
+	profileType := query.ProfileType
+	if profileType == "" {
+		profileType = ParcaCPUProfileType
+	}
+
+	params := url.Values{
+		"mode":        {"MODE_MERGE"},
+		"report_type": {"REPORT_TYPE_PPROF"},
+		"merge.query": {profileType + query.Selector},
+		"merge.start": {query.Start.UTC().Format(time.RFC3339Nano)},
+		"merge.end":   {query.End.UTC().Format(time.RFC3339Nano)},
+	}
+
+	url := strings.TrimSuffix(p.opts.URL, "/") + "/profiles/query?" + params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	body, err := send(p.client, req, p.opts.MaxBodySize)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp parcaQueryResponse
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return resp.Pprof, nil
+}
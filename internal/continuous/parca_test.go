@@ -0,0 +1,81 @@
+package continuous_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/continuous"
+	"github.com/macabu/cpgo/internal/httpclient"
+)
+
+func TestParca(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	start := time.Date(2023, time.August, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	t.Run("given a query, then it returns the merged profile", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/api/profiles/query", r.URL.Path)
+
+			query := r.URL.Query()
+			require.Equal(t, "MODE_MERGE", query.Get("mode"))
+			require.Equal(t, "REPORT_TYPE_PPROF", query.Get("report_type"))
+			require.Equal(t, continuous.ParcaCPUProfileType+`{service="checkout"}`, query.Get("merge.query"))
+			require.Equal(t, "2023-08-01T00:00:00Z", query.Get("merge.start"))
+			require.Equal(t, "2023-08-02T00:00:00Z", query.Get("merge.end"))
+
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"pprof":"cHJvZmlsZQ==","total":"10"}`))
+		}))
+		t.Cleanup(srv.Close)
+
+		parca := continuous.NewParca(srv.Client(), continuous.Options{URL: srv.URL + "/api/"})
+
+		prof, err := parca.MergedProfile(ctx, continuous.Query{Selector: `{service="checkout"}`, Start: start, End: end})
+		require.NoError(t, err)
+		require.Equal(t, "profile", string(prof))
+	})
+
+	t.Run("when the response is larger than allowed, then an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(`{"pprof":"cHJvZmlsZQ=="}`))
+		}))
+		t.Cleanup(srv.Close)
+
+		parca := continuous.NewParca(srv.Client(), continuous.Options{URL: srv.URL, MaxBodySize: 8})
+
+		prof, err := parca.MergedProfile(ctx, continuous.Query{Start: start, End: end})
+		require.ErrorIs(t, err, httpclient.ErrBodyTooLarge)
+		require.Nil(t, prof)
+	})
+
+	t.Run("when the server is unavailable, then a transient error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		t.Cleanup(srv.Close)
+
+		parca := continuous.NewParca(srv.Client(), continuous.Options{URL: srv.URL})
+
+		_, err := parca.MergedProfile(ctx, continuous.Query{Start: start, End: end})
+
+		var apiErr *continuous.Error
+
+		require.ErrorAs(t, err, &apiErr)
+		require.False(t, apiErr.Permanent())
+	})
+}
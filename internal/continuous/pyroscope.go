@@ -0,0 +1,74 @@
+package continuous
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// PyroscopeCPUProfileType is the CPU profile type pushed by the Pyroscope Go SDK and Grafana Agent.
+const PyroscopeCPUProfileType = "process_cpu:cpu:nanoseconds:cpu:nanoseconds"
+
+// Pyroscope queries a Grafana Pyroscope server through its Connect API.
+// Reference: https://grafana.com/docs/pyroscope/latest/reference-server-api/
+type Pyroscope struct {
+	client *http.Client
+	opts   Options
+}
+
+func NewPyroscope(client *http.Client, opts Options) *Pyroscope {
+	return &Pyroscope{
+		client: client,
+		opts:   opts,
+	}
+}
+
+// MergedProfile calls the SelectMergeProfile method, whose response is a pprof profile.
+func (p Pyroscope) MergedProfile(ctx context.Context, query Query) ([]byte, error) {
+	profileType := query.ProfileType
+	if profileType == "" {
+		profileType = PyroscopeCPUProfileType
+	}
+
+	// The message is encoded by hand, as a Protobuf library is not worth it for four fields.
+	var msg []byte
+
+	msg = appendProtoString(msg, 1, profileType)
+	msg = appendProtoString(msg, 2, query.Selector)
+	msg = appendProtoInt64(msg, 3, query.Start.UnixMilli())
+	msg = appendProtoInt64(msg, 4, query.End.UnixMilli())
+
+	url := strings.TrimSuffix(p.opts.URL, "/") + "/querier.v1.QuerierService/SelectMergeProfile"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(msg))
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/proto")
+	req.Header.Set("Connect-Protocol-Version", "1")
+
+	return send(p.client, req, p.opts.MaxBodySize)
+}
+
+// appendProtoString appends a length-delimited field to the Protobuf message.
+func appendProtoString(msg []byte, field int, value string) []byte {
+	const wireTypeLen = 2
+
+	msg = binary.AppendUvarint(msg, uint64(field<<3|wireTypeLen))
+	msg = binary.AppendUvarint(msg, uint64(len(value)))
+
+	return append(msg, value...)
+}
+
+// appendProtoInt64 appends a varint field to the Protobuf message.
+func appendProtoInt64(msg []byte, field int, value int64) []byte {
+	const wireTypeVarint = 0
+
+	msg = binary.AppendUvarint(msg, uint64(field<<3|wireTypeVarint))
+
+	return binary.AppendUvarint(msg, uint64(value))
+}
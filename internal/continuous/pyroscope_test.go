@@ -0,0 +1,107 @@
+package continuous_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/continuous"
+)
+
+// decodeProto decodes the string and varint fields of a Protobuf message, by field number.
+func decodeProto(t *testing.T, msg []byte) map[uint64]any {
+	t.Helper()
+
+	fields := make(map[uint64]any)
+
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		require.Positive(t, n)
+
+		msg = msg[n:]
+
+		value, n := binary.Uvarint(msg)
+		require.Positive(t, n)
+
+		msg = msg[n:]
+
+		switch tag & 0b111 {
+		case 0:
+			fields[tag>>3] = int64(value)
+		case 2:
+			fields[tag>>3] = string(msg[:value])
+			msg = msg[value:]
+		default:
+			t.Fatalf("unexpected wire type in tag %v", tag)
+		}
+	}
+
+	return fields
+}
+
+func TestPyroscope(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	start := time.Date(2023, time.August, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	t.Run("given a query, then it returns the merged profile", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodPost, r.Method)
+			require.Equal(t, "/querier.v1.QuerierService/SelectMergeProfile", r.URL.Path)
+			require.Equal(t, "application/proto", r.Header.Get("Content-Type"))
+
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, map[uint64]any{
+				1: continuous.PyroscopeCPUProfileType,
+				2: `{service_name="checkout"}`,
+				3: start.UnixMilli(),
+				4: end.UnixMilli(),
+			}, decodeProto(t, body))
+
+			w.Header().Set("Content-Type", "application/proto")
+			_, _ = w.Write([]byte("profile"))
+		}))
+		t.Cleanup(srv.Close)
+
+		pyroscope := continuous.NewPyroscope(srv.Client(), continuous.Options{URL: srv.URL})
+
+		prof, err := pyroscope.MergedProfile(ctx, continuous.Query{Selector: `{service_name="checkout"}`, Start: start, End: end})
+		require.NoError(t, err)
+		require.Equal(t, "profile", string(prof))
+	})
+
+	t.Run("when the query is invalid, then a permanent error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"code":"invalid_argument","message":"parse error"}`))
+		}))
+		t.Cleanup(srv.Close)
+
+		pyroscope := continuous.NewPyroscope(srv.Client(), continuous.Options{URL: srv.URL})
+
+		prof, err := pyroscope.MergedProfile(ctx, continuous.Query{Selector: "{", Start: start, End: end})
+		require.Nil(t, prof)
+
+		var apiErr *continuous.Error
+
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+		require.Contains(t, apiErr.Snippet, "parse error")
+		require.True(t, apiErr.Permanent())
+	})
+}
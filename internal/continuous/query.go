@@ -0,0 +1,80 @@
+package continuous
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/macabu/cpgo/internal/httpclient"
+)
+
+const (
+	KindPyroscope = "pyroscope"
+	KindParca     = "parca"
+)
+
+// Query selects the profiles of a service over a time range, to be merged by the continuous profiling server.
+type Query struct {
+	// ProfileType is the server specific ID of the CPU profile type, e.g. process_cpu:cpu:nanoseconds:cpu:nanoseconds.
+	ProfileType string
+	// Selector of the labels of the service, e.g. {service_name="checkout"}.
+	Selector string
+	Start    time.Time
+	End      time.Time
+}
+
+// Querier is implemented by the clients of every supported continuous profiling server.
+type Querier interface {
+	// MergedProfile returns the pprof encoded profile of all the profiles matching the query.
+	MergedProfile(ctx context.Context, query Query) ([]byte, error)
+}
+
+type Options struct {
+	// URL of the HTTP API of the server.
+	URL string
+	// MaxBodySize in bytes of a response, defaults to httpclient.DefaultMaxBodySize.
+	MaxBodySize int64
+}
+
+// New returns the client of the given kind of server.
+func New(kind string, client *http.Client, opts Options) (Querier, error) {
+	switch kind {
+	case KindPyroscope:
+		return NewPyroscope(client, opts), nil
+	case KindParca:
+		return NewParca(client, opts), nil
+	default:
+		return nil, fmt.Errorf("%v: %w", kind, ErrUnsupportedKind)
+	}
+}
+
+// send the request, returning the body of a successful response.
+func send(client *http.Client, req *http.Request, maxBodySize int64) ([]byte, error) {
+	if maxBodySize <= 0 {
+		maxBodySize = httpclient.DefaultMaxBodySize
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client.Do: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{URL: req.URL.String(), StatusCode: resp.StatusCode, Snippet: httpclient.Snippet(body)}
+	}
+
+	if int64(len(body)) > maxBodySize {
+		return nil, httpclient.ErrBodyTooLarge
+	}
+
+	return body, nil
+}
@@ -0,0 +1,39 @@
+package continuous_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/continuous"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		kind         string
+		expectedType continuous.Querier
+		expectedErr  error
+	}{
+		{kind: continuous.KindPyroscope, expectedType: &continuous.Pyroscope{}},
+		{kind: continuous.KindParca, expectedType: &continuous.Parca{}},
+		{kind: "phlare", expectedErr: continuous.ErrUnsupportedKind},
+	}
+
+	for _, tt := range testcases {
+		tt := tt
+
+		t.Run(tt.kind, func(t *testing.T) {
+			t.Parallel()
+
+			querier, err := continuous.New(tt.kind, http.DefaultClient, continuous.Options{})
+			require.ErrorIs(t, err, tt.expectedErr)
+
+			if tt.expectedType != nil {
+				require.IsType(t, tt.expectedType, querier)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	RecordTypeA    = "A"
+	RecordTypeAAAA = "AAAA"
+	RecordTypeSRV  = "SRV"
+)
+
+// Resolver is the subset of *net.Resolver used for discovering instances.
+type Resolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+type DNSOptions struct {
+	// Name to resolve, for SRV it must be the full record name, e.g. _pprof._tcp.checkout.default.svc.
+	Name string
+	// RecordType is one of A, AAAA or SRV. When empty, both A and AAAA records are resolved.
+	RecordType string
+	// Port used for A and AAAA records, SRV records carry their own port.
+	Port int
+	// Scheme of the scrape URL, defaults to http.
+	Scheme string
+	// Path (including the query) of the scrape URL, e.g. /debug/pprof/profile?seconds=30.
+	Path string
+}
+
+type DNS struct {
+	resolver Resolver
+	opts     DNSOptions
+}
+
+func NewDNS(resolver Resolver, opts DNSOptions) *DNS {
+	return &DNS{
+		resolver: resolver,
+		opts:     opts,
+	}
+}
+
+// Targets resolves DNSOptions.Name and returns one target per address found.
+func (d DNS) Targets(ctx context.Context) ([]Target, error) {
+	hostPorts, err := d.lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hostPorts) == 0 {
+		return nil, fmt.Errorf("%v: %w", d.opts.Name, ErrNoInstancesFound)
+	}
+
+	scheme := d.opts.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	targets := make([]Target, 0, len(hostPorts))
+
+	for _, hostPort := range hostPorts {
+		targets = append(targets, Target{URL: scheme + "://" + hostPort + d.opts.Path})
+	}
+
+	return targets, nil
+}
+
+// lookup resolves the records according to the record type. Returns a list of host:port.
+func (d DNS) lookup(ctx context.Context) ([]string, error) {
+	var network string
+
+	switch strings.ToUpper(d.opts.RecordType) {
+	case "":
+		network = "ip"
+	case RecordTypeA:
+		network = "ip4"
+	case RecordTypeAAAA:
+		network = "ip6"
+	case RecordTypeSRV:
+		return d.lookupSRV(ctx)
+	default:
+		return nil, fmt.Errorf("%v: %w", d.opts.RecordType, ErrUnsupportedRecordType)
+	}
+
+	ips, err := d.resolver.LookupIP(ctx, network, d.opts.Name)
+	if err != nil {
+		return nil, fmt.Errorf("resolver.LookupIP: %w", err)
+	}
+
+	port := strconv.Itoa(d.opts.Port)
+	hostPorts := make([]string, 0, len(ips))
+
+	for _, ip := range ips {
+		hostPorts = append(hostPorts, net.JoinHostPort(ip.String(), port))
+	}
+
+	return hostPorts, nil
+}
+
+// lookupSRV resolves the SRV records, using the target and port of each record. Returns a list of host:port.
+func (d DNS) lookupSRV(ctx context.Context) ([]string, error) {
+	_, records, err := d.resolver.LookupSRV(ctx, "", "", d.opts.Name)
+	if err != nil {
+		return nil, fmt.Errorf("resolver.LookupSRV: %w", err)
+	}
+
+	hostPorts := make([]string, 0, len(records))
+
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+
+		hostPorts = append(hostPorts, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+	}
+
+	return hostPorts, nil
+}
@@ -0,0 +1,142 @@
+package discovery_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/discovery"
+)
+
+// fakeResolver stands in for a DNS server, answering from the records it holds.
+type fakeResolver struct {
+	ips     map[string][]net.IP
+	srv     map[string][]*net.SRV
+	err     error
+	network string
+}
+
+func (f *fakeResolver) LookupIP(_ context.Context, network, host string) ([]net.IP, error) {
+	f.network = network
+
+	return f.ips[host], f.err
+}
+
+func (f *fakeResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	return name, f.srv[name], f.err
+}
+
+func TestDNSTargets(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("given A records, then it returns one target per address", func(t *testing.T) {
+		t.Parallel()
+
+		resolver := &fakeResolver{
+			ips: map[string][]net.IP{
+				"checkout.default.svc": {net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")},
+			},
+		}
+
+		dns := discovery.NewDNS(resolver, discovery.DNSOptions{
+			Name:       "checkout.default.svc",
+			RecordType: discovery.RecordTypeA,
+			Port:       6060,
+			Path:       "/debug/pprof/profile?seconds=30",
+		})
+
+		targets, err := dns.Targets(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "ip4", resolver.network)
+		require.EqualValues(t, discovery.StaticTargets([]string{
+			"http://10.0.0.1:6060/debug/pprof/profile?seconds=30",
+			"http://10.0.0.2:6060/debug/pprof/profile?seconds=30",
+		}), targets)
+	})
+
+	t.Run("given AAAA records, then the addresses are bracketed in the target", func(t *testing.T) {
+		t.Parallel()
+
+		resolver := &fakeResolver{
+			ips: map[string][]net.IP{
+				"checkout.default.svc": {net.ParseIP("fd00::1")},
+			},
+		}
+
+		dns := discovery.NewDNS(resolver, discovery.DNSOptions{
+			Name:       "checkout.default.svc",
+			RecordType: discovery.RecordTypeAAAA,
+			Port:       6060,
+			Scheme:     "https",
+			Path:       "/debug/pprof/profile",
+		})
+
+		targets, err := dns.Targets(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "ip6", resolver.network)
+		require.EqualValues(t, discovery.StaticTargets([]string{"https://[fd00::1]:6060/debug/pprof/profile"}), targets)
+	})
+
+	t.Run("given SRV records, then it uses the target and port of each record", func(t *testing.T) {
+		t.Parallel()
+
+		resolver := &fakeResolver{
+			srv: map[string][]*net.SRV{
+				"_pprof._tcp.checkout.default.svc": {
+					{Target: "checkout-0.checkout.default.svc.", Port: 6060},
+					{Target: "checkout-1.checkout.default.svc.", Port: 6061},
+				},
+			},
+		}
+
+		dns := discovery.NewDNS(resolver, discovery.DNSOptions{
+			Name:       "_pprof._tcp.checkout.default.svc",
+			RecordType: discovery.RecordTypeSRV,
+			Path:       "/debug/pprof/profile",
+		})
+
+		targets, err := dns.Targets(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, discovery.StaticTargets([]string{
+			"http://checkout-0.checkout.default.svc:6060/debug/pprof/profile",
+			"http://checkout-1.checkout.default.svc:6061/debug/pprof/profile",
+		}), targets)
+	})
+
+	t.Run("when the name resolves to no records, an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		dns := discovery.NewDNS(&fakeResolver{}, discovery.DNSOptions{Name: "checkout.default.svc"})
+
+		targets, err := dns.Targets(ctx)
+		require.ErrorIs(t, err, discovery.ErrNoInstancesFound)
+		require.Nil(t, targets)
+	})
+
+	t.Run("when the resolver fails, the error is propagated", func(t *testing.T) {
+		t.Parallel()
+
+		mockErr := fmt.Errorf("mock error")
+
+		dns := discovery.NewDNS(&fakeResolver{err: mockErr}, discovery.DNSOptions{Name: "checkout.default.svc"})
+
+		targets, err := dns.Targets(ctx)
+		require.ErrorIs(t, err, mockErr)
+		require.Nil(t, targets)
+	})
+
+	t.Run("when the record type is not supported, an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		dns := discovery.NewDNS(&fakeResolver{}, discovery.DNSOptions{Name: "checkout.default.svc", RecordType: "MX"})
+
+		targets, err := dns.Targets(ctx)
+		require.ErrorIs(t, err, discovery.ErrUnsupportedRecordType)
+		require.Nil(t, targets)
+	})
+}
@@ -0,0 +1,12 @@
+package discovery
+
+import "errors"
+
+var (
+	ErrUnsupportedRecordType      = errors.New("unsupported DNS record type, expected one of A, AAAA or SRV")
+	ErrNoInstancesFound           = errors.New("no instances were discovered")
+	ErrNotInCluster               = errors.New("not running inside a Kubernetes cluster and no kubeconfig was provided")
+	ErrKubeconfigEntryNotFound    = errors.New("could not find entry in kubeconfig")
+	ErrKubeconfigExecNotSupported = errors.New("kubeconfig exec credential plugins are not supported")
+	ErrUnexpectedStatusCode       = errors.New("unexpected status code from the API server")
+)
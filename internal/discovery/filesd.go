@@ -0,0 +1,115 @@
+package discovery
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// metaLabelPrefix marks Prometheus internal labels, they are not attached to the profiles.
+const metaLabelPrefix = "__"
+
+// schemeLabel overrides the scheme of the targets of a group, as in Prometheus.
+const schemeLabel = "__scheme__"
+
+type FileSDOptions struct {
+	// Files in the Prometheus file_sd_configs format, either JSON or YAML. Glob patterns are allowed.
+	Files []string
+	// Scheme of the scrape URL, defaults to http.
+	Scheme string
+	// Path (including the query) of the scrape URL, e.g. /debug/pprof/profile?seconds=30.
+	Path string
+}
+
+// targetGroup is a single entry of a file_sd file.
+type targetGroup struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+type FileSD struct {
+	opts FileSDOptions
+}
+
+func NewFileSD(opts FileSDOptions) *FileSD {
+	return &FileSD{
+		opts: opts,
+	}
+}
+
+// Targets reads all the files, so any change to them is picked up on the next run. Returns a target per host:port listed.
+func (f FileSD) Targets() ([]Target, error) {
+	var targets []Target
+
+	for _, pattern := range f.opts.Files {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("filepath.Glob: %w", err)
+		}
+
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			fileTargets, err := f.readFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", path, err)
+			}
+
+			targets = append(targets, fileTargets...)
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%v: %w", strings.Join(f.opts.Files, ","), ErrNoInstancesFound)
+	}
+
+	return targets, nil
+}
+
+// readFile parses a single file_sd file. JSON files are parsed as YAML, given that YAML is a superset of it.
+func (f FileSD) readFile(path string) ([]Target, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	var groups []targetGroup
+
+	if err := yaml.Unmarshal(content, &groups); err != nil {
+		return nil, fmt.Errorf("yaml.Unmarshal: %w", err)
+	}
+
+	var targets []Target
+
+	for _, group := range groups {
+		scheme := f.opts.Scheme
+		if groupScheme := group.Labels[schemeLabel]; groupScheme != "" {
+			scheme = groupScheme
+		}
+
+		if scheme == "" {
+			scheme = "http"
+		}
+
+		labels := make(map[string]string, len(group.Labels))
+
+		for k, v := range group.Labels {
+			if !strings.HasPrefix(k, metaLabelPrefix) {
+				labels[k] = v
+			}
+		}
+
+		for _, hostPort := range group.Targets {
+			targets = append(targets, Target{
+				URL:    scheme + "://" + hostPort + f.opts.Path,
+				Labels: labels,
+			})
+		}
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,105 @@
+package discovery_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/discovery"
+)
+
+const fileSDJSON = `[
+  {
+    "targets": ["10.0.0.1:6060", "10.0.0.2:6060"],
+    "labels": {"env": "production", "team": "checkout", "__metrics_path__": "/metrics"}
+  }
+]`
+
+const fileSDYAML = `---
+- targets:
+  - 10.0.1.1:6443
+  labels:
+    env: staging
+    __scheme__: https
+`
+
+func TestFileSDTargets(t *testing.T) {
+	t.Parallel()
+
+	t.Run("given JSON and YAML files, then it returns every target with its labels", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a.json"), []byte(fileSDJSON), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "b.yaml"), []byte(fileSDYAML), 0o600))
+
+		fileSD := discovery.NewFileSD(discovery.FileSDOptions{
+			Files: []string{filepath.Join(dir, "*.json"), filepath.Join(dir, "*.yaml")},
+			Path:  "/debug/pprof/profile?seconds=30",
+		})
+
+		targets, err := fileSD.Targets()
+		require.NoError(t, err)
+		require.EqualValues(t, []discovery.Target{
+			{
+				URL:    "http://10.0.0.1:6060/debug/pprof/profile?seconds=30",
+				Labels: map[string]string{"env": "production", "team": "checkout"},
+			},
+			{
+				URL:    "http://10.0.0.2:6060/debug/pprof/profile?seconds=30",
+				Labels: map[string]string{"env": "production", "team": "checkout"},
+			},
+			{
+				URL:    "https://10.0.1.1:6443/debug/pprof/profile?seconds=30",
+				Labels: map[string]string{"env": "staging"},
+			},
+		}, targets)
+	})
+
+	t.Run("when the file changes, then the next call picks up the change", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "targets.yaml")
+
+		require.NoError(t, os.WriteFile(path, []byte(fileSDYAML), 0o600))
+
+		fileSD := discovery.NewFileSD(discovery.FileSDOptions{Files: []string{path}})
+
+		targets, err := fileSD.Targets()
+		require.NoError(t, err)
+		require.Len(t, targets, 1)
+
+		require.NoError(t, os.WriteFile(path, []byte(fileSDJSON), 0o600))
+
+		targets, err = fileSD.Targets()
+		require.NoError(t, err)
+		require.Len(t, targets, 2)
+	})
+
+	t.Run("when no files match, an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		fileSD := discovery.NewFileSD(discovery.FileSDOptions{Files: []string{filepath.Join(t.TempDir(), "*.json")}})
+
+		targets, err := fileSD.Targets()
+		require.ErrorIs(t, err, discovery.ErrNoInstancesFound)
+		require.Nil(t, targets)
+	})
+
+	t.Run("when a file is not in the file_sd format, an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "targets.json")
+
+		require.NoError(t, os.WriteFile(path, []byte(`{"targets": "not-a-list"}`), 0o600))
+
+		fileSD := discovery.NewFileSD(discovery.FileSDOptions{Files: []string{path}})
+
+		targets, err := fileSD.Targets()
+		require.Error(t, err)
+		require.Nil(t, targets)
+	})
+}
@@ -0,0 +1,218 @@
+package discovery
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/macabu/cpgo/internal/httpclient"
+)
+
+const (
+	inClusterTokenPath     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	inClusterCAPath        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	inClusterNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// kubeconfig is the subset of the kubeconfig file format needed to reach the API server.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			TLSServerName            string `yaml:"tls-server-name"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			Username              string `yaml:"username"`
+			Password              string `yaml:"password"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+			Exec                  any    `yaml:"exec"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// apiServer holds everything needed to talk to a Kubernetes API server.
+type apiServer struct {
+	server    string
+	namespace string
+	client    *http.Client
+}
+
+// loadKubeconfig reads the kubeconfig at `path` and builds the API server client for the given (or current) context.
+func loadKubeconfig(path, contextName string) (*apiServer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	var cfg kubeconfig
+
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("yaml.Unmarshal: %w", err)
+	}
+
+	if contextName == "" {
+		contextName = cfg.CurrentContext
+	}
+
+	// Relative paths in a kubeconfig are relative to the file itself.
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+
+		return filepath.Join(filepath.Dir(path), p)
+	}
+
+	for _, kubeCtx := range cfg.Contexts {
+		if kubeCtx.Name != contextName {
+			continue
+		}
+
+		srv := &apiServer{namespace: kubeCtx.Context.Namespace}
+		clientOpts := httpclient.Options{}
+
+		for _, cluster := range cfg.Clusters {
+			if cluster.Name != kubeCtx.Context.Cluster {
+				continue
+			}
+
+			srv.server = cluster.Cluster.Server
+			clientOpts.TLS.ServerName = cluster.Cluster.TLSServerName
+			clientOpts.TLS.InsecureSkipVerify = cluster.Cluster.InsecureSkipTLSVerify
+
+			caPEM, err := dataOrFile(cluster.Cluster.CertificateAuthorityData, resolve(cluster.Cluster.CertificateAuthority))
+			if err != nil {
+				return nil, fmt.Errorf("certificate-authority: %w", err)
+			}
+
+			clientOpts.TLS.CAPEM = caPEM
+		}
+
+		if srv.server == "" {
+			return nil, fmt.Errorf("cluster %q: %w", kubeCtx.Context.Cluster, ErrKubeconfigEntryNotFound)
+		}
+
+		for _, user := range cfg.Users {
+			if user.Name != kubeCtx.Context.User {
+				continue
+			}
+
+			if user.User.Exec != nil {
+				return nil, ErrKubeconfigExecNotSupported
+			}
+
+			certPEM, err := dataOrFile(user.User.ClientCertificateData, resolve(user.User.ClientCertificate))
+			if err != nil {
+				return nil, fmt.Errorf("client-certificate: %w", err)
+			}
+
+			keyPEM, err := dataOrFile(user.User.ClientKeyData, resolve(user.User.ClientKey))
+			if err != nil {
+				return nil, fmt.Errorf("client-key: %w", err)
+			}
+
+			clientOpts.TLS.CertPEM = certPEM
+			clientOpts.TLS.KeyPEM = keyPEM
+
+			clientOpts.BearerToken = user.User.Token
+			clientOpts.BearerTokenFile = resolve(user.User.TokenFile)
+
+			// A token takes precedence over basic auth, as for kubectl.
+			if user.User.Username != "" && clientOpts.BearerToken == "" && clientOpts.BearerTokenFile == "" {
+				clientOpts.BasicAuth = &httpclient.BasicAuth{
+					Username: user.User.Username,
+					Password: user.User.Password,
+				}
+			}
+		}
+
+		client, err := httpclient.New(clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("httpclient.New: %w", err)
+		}
+
+		srv.client = client
+
+		return srv, nil
+	}
+
+	return nil, fmt.Errorf("context %q: %w", contextName, ErrKubeconfigEntryNotFound)
+}
+
+// inClusterConfig builds the API server client from the service account mounted into the pod.
+func inClusterConfig() (*apiServer, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, ErrNotInCluster
+	}
+
+	namespace, err := os.ReadFile(inClusterNamespacePath)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	// Service account tokens are rotated, hence the token file is read on every request.
+	client, err := httpclient.New(httpclient.Options{
+		BearerTokenFile: inClusterTokenPath,
+		TLS:             httpclient.TLS{CAFile: inClusterCAPath},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("httpclient.New: %w", err)
+	}
+
+	return &apiServer{
+		server:    "https://" + net.JoinHostPort(host, port),
+		namespace: strings.TrimSpace(string(namespace)),
+		client:    client,
+	}, nil
+}
+
+// dataOrFile returns the base64 decoded `data` if present, otherwise the contents of the file at `path`, if any.
+func dataOrFile(data, path string) ([]byte, error) {
+	if data != "" {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("base64.DecodeString: %w", err)
+		}
+
+		return decoded, nil
+	}
+
+	if path == "" {
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	return content, nil
+}
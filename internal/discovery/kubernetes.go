@@ -0,0 +1,151 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const (
+	defaultNamespace = "default"
+	podPhaseRunning  = "Running"
+)
+
+type KubernetesOptions struct {
+	// Kubeconfig path, when empty the in-cluster service account is used.
+	Kubeconfig string
+	// Context to use from the kubeconfig, defaults to its current context.
+	Context string
+	// Namespace of the pods, defaults to the one from the kubeconfig context or service account.
+	Namespace string
+	// LabelSelector to filter the pods, e.g. app=checkout,tier!=canary.
+	LabelSelector string
+	// Port name or number of the pprof server in the pod.
+	Port string
+	// Scheme of the pprof server in the pod, either http (default) or https.
+	Scheme string
+	// Path (including the query) of the pprof handler, e.g. /debug/pprof/profile?seconds=30.
+	Path string
+}
+
+type Kubernetes struct {
+	apiServer *apiServer
+	opts      KubernetesOptions
+}
+
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Status struct {
+			Phase string `json:"phase"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// NewKubernetes builds the API server client, either from the kubeconfig or the in-cluster service account.
+func NewKubernetes(opts KubernetesOptions) (*Kubernetes, error) {
+	var (
+		srv *apiServer
+		err error
+	)
+
+	if opts.Kubeconfig != "" {
+		srv, err = loadKubeconfig(opts.Kubeconfig, opts.Context)
+	} else {
+		srv, err = inClusterConfig()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Namespace != "" {
+		srv.namespace = opts.Namespace
+	}
+
+	if srv.namespace == "" {
+		srv.namespace = defaultNamespace
+	}
+
+	return &Kubernetes{
+		apiServer: srv,
+		opts:      opts,
+	}, nil
+}
+
+// Client is authenticated against the API server, and must be used to scrape the targets through the pod proxy.
+func (k Kubernetes) Client() *http.Client {
+	return k.apiServer.client
+}
+
+// Targets lists the running pods matching the label selector. Returns the API server pod proxy URL of each as a target.
+func (k Kubernetes) Targets(ctx context.Context) ([]Target, error) {
+	pods, err := k.listPods(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listPods: %w", err)
+	}
+
+	targets := make([]Target, 0, len(pods.Items))
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != podPhaseRunning {
+			continue
+		}
+
+		podRef := pod.Metadata.Name + ":" + k.opts.Port
+		if k.opts.Scheme != "" {
+			podRef = k.opts.Scheme + ":" + podRef
+		}
+
+		targets = append(targets, Target{URL: fmt.Sprintf(
+			"%v/api/v1/namespaces/%v/pods/%v/proxy%v",
+			k.apiServer.server, url.PathEscape(k.apiServer.namespace), url.PathEscape(podRef), k.opts.Path,
+		)})
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%v/%v: %w", k.apiServer.namespace, k.opts.LabelSelector, ErrNoInstancesFound)
+	}
+
+	return targets, nil
+}
+
+// listPods in the namespace matching the label selector.
+func (k Kubernetes) listPods(ctx context.Context) (*podList, error) {
+	query := url.Values{}
+	if k.opts.LabelSelector != "" {
+		query.Set("labelSelector", k.opts.LabelSelector)
+	}
+
+	podsURL := fmt.Sprintf("%v/api/v1/namespaces/%v/pods?%v", k.apiServer.server, url.PathEscape(k.apiServer.namespace), query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, podsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := k.apiServer.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client.Do: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %w", resp.Status, ErrUnexpectedStatusCode)
+	}
+
+	var pods podList
+
+	if err := json.NewDecoder(resp.Body).Decode(&pods); err != nil {
+		return nil, fmt.Errorf("json.NewDecoder.Decode: %w", err)
+	}
+
+	return &pods, nil
+}
@@ -0,0 +1,195 @@
+package discovery_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/discovery"
+)
+
+const kubeconfigTemplate = `---
+apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+- name: test-cluster
+  cluster:
+    server: %v
+    certificate-authority-data: %v
+contexts:
+- name: test
+  context:
+    cluster: test-cluster
+    user: test-user
+    namespace: from-context
+users:
+- name: test-user
+  user:
+    token: %v
+`
+
+// newFakeAPIServer stands in for the Kubernetes API server, serving a pod list and the pod proxy.
+func newFakeAPIServer(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v1/namespaces/prod/pods", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		if r.URL.Query().Get("labelSelector") != "app=checkout" {
+			_, _ = w.Write([]byte(`{"items":[]}`))
+
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"items": []map[string]any{
+				{"metadata": map[string]any{"name": "checkout-0"}, "status": map[string]any{"phase": "Running"}},
+				{"metadata": map[string]any{"name": "checkout-1"}, "status": map[string]any{"phase": "Pending"}},
+				{"metadata": map[string]any{"name": "checkout-2"}, "status": map[string]any{"phase": "Running"}},
+			},
+		})
+	})
+
+	mux.HandleFunc("/api/v1/namespaces/prod/pods/checkout-0:6060/proxy/debug/pprof/profile", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		_, _ = w.Write([]byte("profile of " + r.URL.Query().Get("seconds")))
+	})
+
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func writeKubeconfig(t *testing.T, srv *httptest.Server, token string) string {
+	t.Helper()
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	content := fmt.Sprintf(kubeconfigTemplate, srv.URL, base64.StdEncoding.EncodeToString(caPEM), token)
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestKubernetesTargets(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	token := "service-account-token"
+
+	t.Run("given running pods matching the selector, then it returns their pod proxy URL", func(t *testing.T) {
+		t.Parallel()
+
+		srv := newFakeAPIServer(t, token)
+
+		kube, err := discovery.NewKubernetes(discovery.KubernetesOptions{
+			Kubeconfig:    writeKubeconfig(t, srv, token),
+			Namespace:     "prod",
+			LabelSelector: "app=checkout",
+			Port:          "6060",
+			Path:          "/debug/pprof/profile?seconds=30",
+		})
+		require.NoError(t, err)
+
+		targets, err := kube.Targets(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, discovery.StaticTargets([]string{
+			srv.URL + "/api/v1/namespaces/prod/pods/checkout-0:6060/proxy/debug/pprof/profile?seconds=30",
+			srv.URL + "/api/v1/namespaces/prod/pods/checkout-2:6060/proxy/debug/pprof/profile?seconds=30",
+		}), targets)
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, targets[0].URL, nil)
+		require.NoError(t, err)
+
+		resp, err := kube.Client().Do(req)
+		require.NoError(t, err)
+
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "profile of 30", string(body))
+	})
+
+	t.Run("when no pods match the selector, an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		srv := newFakeAPIServer(t, token)
+
+		kube, err := discovery.NewKubernetes(discovery.KubernetesOptions{
+			Kubeconfig:    writeKubeconfig(t, srv, token),
+			Namespace:     "prod",
+			LabelSelector: "app=unknown",
+			Port:          "6060",
+		})
+		require.NoError(t, err)
+
+		targets, err := kube.Targets(ctx)
+		require.ErrorIs(t, err, discovery.ErrNoInstancesFound)
+		require.Nil(t, targets)
+	})
+
+	t.Run("when the API server rejects the credentials, an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		srv := newFakeAPIServer(t, token)
+
+		kube, err := discovery.NewKubernetes(discovery.KubernetesOptions{
+			Kubeconfig:    writeKubeconfig(t, srv, "wrong-token"),
+			Namespace:     "prod",
+			LabelSelector: "app=checkout",
+			Port:          "6060",
+		})
+		require.NoError(t, err)
+
+		targets, err := kube.Targets(ctx)
+		require.ErrorIs(t, err, discovery.ErrUnexpectedStatusCode)
+		require.Nil(t, targets)
+	})
+
+	t.Run("when the kubeconfig context does not exist, an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		srv := newFakeAPIServer(t, token)
+
+		kube, err := discovery.NewKubernetes(discovery.KubernetesOptions{
+			Kubeconfig: writeKubeconfig(t, srv, token),
+			Context:    "does-not-exist",
+		})
+		require.ErrorIs(t, err, discovery.ErrKubeconfigEntryNotFound)
+		require.Nil(t, kube)
+	})
+}
+
+func TestNewKubernetesInCluster(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	kube, err := discovery.NewKubernetes(discovery.KubernetesOptions{})
+	require.ErrorIs(t, err, discovery.ErrNotInCluster)
+	require.Nil(t, kube)
+}
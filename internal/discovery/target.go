@@ -0,0 +1,19 @@
+package discovery
+
+// Target is a single instance to be scraped.
+type Target struct {
+	URL string
+	// Labels describe the target, they are attached to every sample of its profile.
+	Labels map[string]string
+}
+
+// StaticTargets turns a list of URLs into targets without labels.
+func StaticTargets(urls []string) []Target {
+	targets := make([]Target, 0, len(urls))
+
+	for _, url := range urls {
+		targets = append(targets, Target{URL: url})
+	}
+
+	return targets
+}
@@ -10,6 +10,7 @@ type Flags struct {
 	GithubToken string
 	ConfigPath  string
 	LogVerbose  bool
+	ListenAddr  string
 }
 
 // Parse command line flags into a flags.Flags struct.
@@ -39,6 +40,13 @@ func Parse(programName string, args []string) (Flags, string, error) {
 
 	flagSet.BoolVar(&flags.LogVerbose, "verbose", false, "Whether to log debug messages")
 
+	flagSet.StringVar(
+		&flags.ListenAddr,
+		"listenAddr",
+		"",
+		"The address to listen on for profiles pushed by the backends, e.g. :8080. Disabled when empty",
+	)
+
 	if err := flagSet.Parse(args); err != nil {
 		return Flags{}, buf.String(), fmt.Errorf("flagSet.Parse: %w", err)
 	}
@@ -24,11 +24,12 @@ func TestParse(t *testing.T) {
 		},
 		{
 			name: "when valid options are passed, no error is returned",
-			args: []string{"-verbose", "-githubToken", "my-token", "-configPath", "/path/to/config.sample.yaml"},
+			args: []string{"-verbose", "-githubToken", "my-token", "-configPath", "/path/to/config.sample.yaml", "-listenAddr", ":8080"},
 			expectedFlags: flags.Flags{
 				GithubToken: "my-token",
 				ConfigPath:  "/path/to/config.sample.yaml",
 				LogVerbose:  true,
+				ListenAddr:  ":8080",
 			},
 		},
 	}
@@ -13,6 +13,7 @@ import (
 	"golang.org/x/oauth2"
 
 	"github.com/macabu/cpgo/internal/gitops"
+	"github.com/macabu/cpgo/internal/retry"
 )
 
 type Options struct {
@@ -21,8 +22,15 @@ type Options struct {
 	MainBranch string
 }
 
+// File committed next to the PGO file, e.g. the same profile in another format.
+type File struct {
+	Path    string
+	Content []byte
+}
+
 type Client struct {
 	github *github.Client
+	retry  retry.Policy
 }
 
 func NewClient(client *github.Client) *Client {
@@ -38,25 +46,56 @@ func NewClientWithAccessToken(ctx context.Context, accessToken string) *Client {
 	return NewClient(github.NewClient(tc))
 }
 
+// WithRetryPolicy returns a copy of the client that retries the transient errors of every call according to `policy`.
+func (c Client) WithRetryPolicy(policy retry.Policy) *Client {
+	c.retry = policy
+
+	return &c
+}
+
 // ExistingPGOFileURL searches for the Options.Filename in the repository. Returns a signed URL to download it.
 func (c Client) ExistingPGOFileURL(ctx context.Context, opts Options) (string, error) {
-	fileContent, _, resp, err := c.github.Repositories.GetContents(ctx, opts.Repo.Org, opts.Repo.Name, opts.Filename, nil)
-	if err != nil {
-		if resp.StatusCode == http.StatusNotFound {
-			return "", fmt.Errorf("github.Repositories.GetContents: %w", gitops.ErrPGOFileNotFound)
+	var fileContent *github.RepositoryContent
+
+	err := retry.Do(ctx, c.retry, func(ctx context.Context) error {
+		var (
+			resp *github.Response
+			err  error
+		)
+
+		fileContent, _, resp, err = c.github.Repositories.GetContents(ctx, opts.Repo.Org, opts.Repo.Name, opts.Filename, nil)
+		if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+			return retry.Permanent(gitops.ErrPGOFileNotFound)
 		}
 
+		return classifyError(err)
+	})
+	if err != nil {
 		return "", fmt.Errorf("github.Repositories.GetContents: %w", err)
 	}
 
 	return *fileContent.DownloadURL, nil
 }
 
-// UpdatePGOFile creates the blob, branch and a pull request with the new PGO file. Returns the pull request URL.
-func (c Client) UpdatePGOFile(ctx context.Context, opts Options, fileContent []byte) (string, error) {
-	blobSHA, err := c.createBlob(ctx, opts, fileContent)
-	if err != nil {
-		return "", fmt.Errorf("createBlob: %w", err)
+// UpdatePGOFile creates the blobs, branch and a pull request with the new PGO file, and the companion files if any.
+// Returns the pull request URL.
+func (c Client) UpdatePGOFile(ctx context.Context, opts Options, fileContent []byte, companions ...File) (string, error) {
+	files := append([]File{{Path: opts.Filename, Content: fileContent}}, companions...)
+
+	entries := make([]*github.TreeEntry, 0, len(files))
+
+	for _, file := range files {
+		blobSHA, err := c.createBlob(ctx, opts, file.Content)
+		if err != nil {
+			return "", fmt.Errorf("createBlob: %w", err)
+		}
+
+		entries = append(entries, &github.TreeEntry{
+			SHA:  blobSHA,
+			Type: github.String("blob"),
+			Mode: github.String("100644"),
+			Path: github.String(file.Path),
+		})
 	}
 
 	mainBranchRef, err := c.findMainBranchRef(ctx, opts)
@@ -64,7 +103,7 @@ func (c Client) UpdatePGOFile(ctx context.Context, opts Options, fileContent []b
 		return "", fmt.Errorf("findMainBranchRef: %w", err)
 	}
 
-	tree, err := c.createTree(ctx, opts, *blobSHA, *mainBranchRef.Object.SHA)
+	tree, err := c.createTree(ctx, opts, entries, *mainBranchRef.Object.SHA)
 	if err != nil {
 		return "", fmt.Errorf("createTree: %w", err)
 	}
@@ -91,9 +130,17 @@ func (c Client) UpdatePGOFile(ctx context.Context, opts Options, fileContent []b
 func (c Client) createBlob(ctx context.Context, opts Options, fileContent []byte) (*string, error) {
 	content := base64.StdEncoding.EncodeToString(fileContent)
 
-	blob, _, err := c.github.Git.CreateBlob(ctx, opts.Repo.Org, opts.Repo.Name, &github.Blob{
-		Content:  github.String(content),
-		Encoding: github.String("base64"),
+	var blob *github.Blob
+
+	err := retry.Do(ctx, c.retry, func(ctx context.Context) error {
+		var err error
+
+		blob, _, err = c.github.Git.CreateBlob(ctx, opts.Repo.Org, opts.Repo.Name, &github.Blob{
+			Content:  github.String(content),
+			Encoding: github.String("base64"),
+		})
+
+		return classifyError(err)
 	})
 	if err != nil {
 		return nil, fmt.Errorf("github.Git.CreateBlob: %w", err)
@@ -104,7 +151,15 @@ func (c Client) createBlob(ctx context.Context, opts Options, fileContent []byte
 
 // findMainBranchRef lists all refs and find the one used as the main branch based on the options. Returns the ref obj.
 func (c Client) findMainBranchRef(ctx context.Context, opts Options) (*github.Reference, error) {
-	refs, _, err := c.github.Git.ListMatchingRefs(ctx, opts.Repo.Org, opts.Repo.Name, nil)
+	var refs []*github.Reference
+
+	err := retry.Do(ctx, c.retry, func(ctx context.Context) error {
+		var err error
+
+		refs, _, err = c.github.Git.ListMatchingRefs(ctx, opts.Repo.Org, opts.Repo.Name, nil)
+
+		return classifyError(err)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("github.Git.ListMatchingRefs: %w", err)
 	}
@@ -118,16 +173,17 @@ func (c Client) findMainBranchRef(ctx context.Context, opts Options) (*github.Re
 	return nil, fmt.Errorf("could not find ref for branch %v", opts.MainBranch)
 }
 
-// createTree using the main branch as the base of the tree. Returns the tree object.
-func (c Client) createTree(ctx context.Context, opts Options, blobSHA, mainRef string) (*github.Tree, error) {
-	entry := &github.TreeEntry{
-		SHA:  github.String(blobSHA),
-		Type: github.String("blob"),
-		Mode: github.String("100644"),
-		Path: github.String(opts.Filename),
-	}
+// createTree with the blob entries, using the main branch as the base of the tree. Returns the tree object.
+func (c Client) createTree(ctx context.Context, opts Options, entries []*github.TreeEntry, mainRef string) (*github.Tree, error) {
+	var tree *github.Tree
+
+	err := retry.Do(ctx, c.retry, func(ctx context.Context) error {
+		var err error
+
+		tree, _, err = c.github.Git.CreateTree(ctx, opts.Repo.Org, opts.Repo.Name, mainRef, entries)
 
-	tree, _, err := c.github.Git.CreateTree(ctx, opts.Repo.Org, opts.Repo.Name, mainRef, []*github.TreeEntry{entry})
+		return classifyError(err)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("github.Git.CreateTree: %w", err)
 	}
@@ -152,7 +208,15 @@ func (c Client) commitFile(ctx context.Context, opts Options, tree *github.Tree,
 		},
 	}
 
-	commitRes, _, err := c.github.Git.CreateCommit(ctx, opts.Repo.Org, opts.Repo.Name, commitReq)
+	var commitRes *github.Commit
+
+	err := retry.Do(ctx, c.retry, func(ctx context.Context) error {
+		var err error
+
+		commitRes, _, err = c.github.Git.CreateCommit(ctx, opts.Repo.Org, opts.Repo.Name, commitReq)
+
+		return classifyError(err)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("github.Git.CreateCommit: %w", err)
 	}
@@ -164,11 +228,39 @@ func (c Client) commitFile(ctx context.Context, opts Options, tree *github.Tree,
 func (c Client) createNewRef(ctx context.Context, opts Options, commitSHA *string) (*string, error) {
 	refName := "refs/heads/cpgo-update-" + strconv.Itoa(int(time.Now().Unix()))
 
-	ref, _, err := c.github.Git.CreateRef(ctx, opts.Repo.Org, opts.Repo.Name, &github.Reference{
-		Ref: github.String(refName),
-		Object: &github.GitObject{
-			SHA: commitSHA,
-		},
+	var (
+		ref      *github.Reference
+		attempts int
+	)
+
+	err := retry.Do(ctx, c.retry, func(ctx context.Context) error {
+		attempts++
+
+		// Creating a ref is not idempotent: a failed attempt, e.g. with a server error, may have created it anyway, in
+		// which case creating it again would be rejected as it already exists.
+		if attempts > 1 {
+			existing, resp, err := c.github.Git.GetRef(ctx, opts.Repo.Org, opts.Repo.Name, refName)
+
+			switch {
+			case err == nil && existing.GetObject().GetSHA() == *commitSHA:
+				ref = existing
+
+				return nil
+			case err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound):
+				return classifyError(err)
+			}
+		}
+
+		var err error
+
+		ref, _, err = c.github.Git.CreateRef(ctx, opts.Repo.Org, opts.Repo.Name, &github.Reference{
+			Ref: github.String(refName),
+			Object: &github.GitObject{
+				SHA: commitSHA,
+			},
+		})
+
+		return classifyError(err)
 	})
 	if err != nil {
 		return nil, fmt.Errorf("github.Git.CreateRef: %w", err)
@@ -187,11 +279,42 @@ func (c Client) openPullRequest(ctx context.Context, opts Options, prRef, mainRe
 	title := fmt.Sprintf("Update PGO file [%v]", time.Now().Format(time.RFC3339))
 	body := "This pull request updates the PGO file with newer traces.\nFeel free to merge or close it."
 
-	pr, _, err := c.github.PullRequests.Create(ctx, opts.Repo.Org, opts.Repo.Name, &github.NewPullRequest{
-		Title: github.String(title),
-		Head:  github.String(head),
-		Base:  github.String(base),
-		Body:  github.String(body),
+	var (
+		pr       *github.PullRequest
+		attempts int
+	)
+
+	err := retry.Do(ctx, c.retry, func(ctx context.Context) error {
+		attempts++
+
+		// As for the ref, a failed attempt may have opened the pull request anyway.
+		if attempts > 1 {
+			prs, _, err := c.github.PullRequests.List(ctx, opts.Repo.Org, opts.Repo.Name, &github.PullRequestListOptions{
+				State: "open",
+				Head:  opts.Repo.Org + ":" + head,
+				Base:  base,
+			})
+			if err != nil {
+				return classifyError(err)
+			}
+
+			if len(prs) > 0 {
+				pr = prs[0]
+
+				return nil
+			}
+		}
+
+		var err error
+
+		pr, _, err = c.github.PullRequests.Create(ctx, opts.Repo.Org, opts.Repo.Name, &github.NewPullRequest{
+			Title: github.String(title),
+			Head:  github.String(head),
+			Base:  github.String(base),
+			Body:  github.String(body),
+		})
+
+		return classifyError(err)
 	})
 	if err != nil {
 		return "", fmt.Errorf("github.PullRequests.Create: %w", err)
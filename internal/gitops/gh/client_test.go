@@ -2,9 +2,13 @@ package gh_test
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/url"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/go-github/v53/github"
 	"github.com/migueleliasweb/go-github-mock/src/mock"
@@ -12,6 +16,7 @@ import (
 
 	"github.com/macabu/cpgo/internal/gitops"
 	"github.com/macabu/cpgo/internal/gitops/gh"
+	"github.com/macabu/cpgo/internal/retry"
 )
 
 func TestExistingPGOFileURL(t *testing.T) {
@@ -205,6 +210,59 @@ func TestUpdatePGOFile(t *testing.T) {
 		require.NotNil(t, validURL)
 	})
 
+	t.Run("given companion files, then they are committed alongside the PGO file", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			blobs atomic.Int32
+			paths []string
+		)
+
+		mockedHTTPClient := mock.NewMockedHTTPClient(
+			mock.WithRequestMatchHandler(
+				mock.PostReposGitBlobsByOwnerByRepo,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					blobSHA := fmt.Sprintf("blob-sha-%v", blobs.Add(1))
+
+					_, _ = w.Write(mock.MustMarshal(github.Blob{
+						SHA: &blobSHA,
+					}))
+				}),
+			),
+			mockValidListMatchingRefs,
+			mock.WithRequestMatchHandler(
+				mock.PostReposGitTreesByOwnerByRepo,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var req struct {
+						Tree []github.TreeEntry `json:"tree"`
+					}
+
+					_ = json.NewDecoder(r.Body).Decode(&req)
+
+					for _, entry := range req.Tree {
+						paths = append(paths, entry.GetPath())
+					}
+
+					_, _ = w.Write(mock.MustMarshal(github.Tree{}))
+				}),
+			),
+			mockValidCreateCommit,
+			mockValidCreateRef,
+			mockValidCreatePullRequest,
+		)
+
+		client := gh.NewClient(github.NewClient(mockedHTTPClient))
+
+		pullRequestURL, err := client.UpdatePGOFile(ctx, opts, []byte("some content"), gh.File{
+			Path:    "default.pgo.preprofile",
+			Content: []byte("GO PREPROFILE V1\n"),
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, pullRequestURL)
+		require.Equal(t, int32(2), blobs.Load())
+		require.Equal(t, []string{"default.pgo", "default.pgo.preprofile"}, paths)
+	})
+
 	t.Run("when there is a problem uploading the blob, an error is returned", func(t *testing.T) {
 		t.Parallel()
 
@@ -391,6 +449,165 @@ func TestUpdatePGOFile(t *testing.T) {
 	})
 }
 
+func TestClientWithRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	opts := gh.Options{
+		Repo: gh.Repository{
+			Org:  "my-org",
+			Name: "my-repo",
+		},
+		Filename:   "default.pgo",
+		MainBranch: "main",
+	}
+
+	policy := retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+	t.Run("given a transient error from the provider, then the call is retried", func(t *testing.T) {
+		t.Parallel()
+
+		var attempts atomic.Int32
+
+		mockDownloadURL := "https://github.com/my-org/my-repo/blob/main/default.pgo"
+
+		mockedHTTPClient := mock.NewMockedHTTPClient(
+			mock.WithRequestMatchHandler(
+				mock.GetReposContentsByOwnerByRepoByPath,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					if attempts.Add(1) == 1 {
+						mock.WriteError(w, http.StatusBadGateway, "try again")
+
+						return
+					}
+
+					_, _ = w.Write(mock.MustMarshal(github.RepositoryContent{
+						DownloadURL: &mockDownloadURL,
+					}))
+				}),
+			),
+		)
+
+		client := gh.NewClient(github.NewClient(mockedHTTPClient)).WithRetryPolicy(policy)
+
+		downloadURL, err := client.ExistingPGOFileURL(ctx, opts)
+		require.NoError(t, err)
+		require.Equal(t, mockDownloadURL, downloadURL)
+		require.Equal(t, int32(2), attempts.Load())
+	})
+
+	t.Run("given a permanent error from the provider, then the call is not retried", func(t *testing.T) {
+		t.Parallel()
+
+		var attempts atomic.Int32
+
+		mockedHTTPClient := mock.NewMockedHTTPClient(
+			mock.WithRequestMatchHandler(
+				mock.PostReposGitBlobsByOwnerByRepo,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					attempts.Add(1)
+
+					mock.WriteError(w, http.StatusUnprocessableEntity, "invalid blob")
+				}),
+			),
+		)
+
+		client := gh.NewClient(github.NewClient(mockedHTTPClient)).WithRetryPolicy(policy)
+
+		pullRequestURL, err := client.UpdatePGOFile(ctx, opts, []byte("some content"))
+		require.Error(t, err)
+		require.True(t, retry.IsPermanent(err))
+		require.Empty(t, pullRequestURL)
+		require.Equal(t, int32(1), attempts.Load())
+	})
+
+	t.Run("when creating the ref and pull request fails after they were created, then the retries find them", func(t *testing.T) {
+		t.Parallel()
+
+		var refAttempts, prAttempts atomic.Int32
+
+		commitSHA := "new-commit-sha"
+		prHTMLURL := "https://github.com/my-org/my-repo/pulls/1"
+
+		mockedHTTPClient := mock.NewMockedHTTPClient(
+			mock.WithRequestMatch(mock.PostReposGitBlobsByOwnerByRepo, github.Blob{SHA: github.String("blob-sha")}),
+			mock.WithRequestMatch(
+				mock.EndpointPattern{Pattern: "/repos/{owner}/{repo}/git/matching-refs/", Method: "GET"},
+				[]*github.Reference{{Ref: github.String("refs/heads/main"), Object: &github.GitObject{SHA: github.String("main-sha")}}},
+			),
+			mock.WithRequestMatch(mock.PostReposGitTreesByOwnerByRepo, github.Tree{}),
+			mock.WithRequestMatch(mock.PostReposGitCommitsByOwnerByRepo, github.Commit{SHA: &commitSHA}),
+			mock.WithRequestMatchHandler(
+				mock.PostReposGitRefsByOwnerByRepo,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					if refAttempts.Add(1) == 1 {
+						mock.WriteError(w, http.StatusBadGateway, "created, but timed out")
+
+						return
+					}
+
+					mock.WriteError(w, http.StatusUnprocessableEntity, "Reference already exists")
+				}),
+			),
+			mock.WithRequestMatchHandler(
+				mock.GetReposGitRefByOwnerByRepoByRef,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					_, _ = w.Write(mock.MustMarshal(github.Reference{
+						Ref:    github.String("refs/" + r.URL.Path[len("/repos/my-org/my-repo/git/ref/"):]),
+						Object: &github.GitObject{SHA: &commitSHA},
+					}))
+				}),
+			),
+			mock.WithRequestMatchHandler(
+				mock.PostReposPullsByOwnerByRepo,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					if prAttempts.Add(1) == 1 {
+						mock.WriteError(w, http.StatusBadGateway, "opened, but timed out")
+
+						return
+					}
+
+					mock.WriteError(w, http.StatusUnprocessableEntity, "A pull request already exists")
+				}),
+			),
+			mock.WithRequestMatch(mock.GetReposPullsByOwnerByRepo, []*github.PullRequest{{HTMLURL: &prHTMLURL}}),
+		)
+
+		client := gh.NewClient(github.NewClient(mockedHTTPClient)).WithRetryPolicy(policy)
+
+		pullRequestURL, err := client.UpdatePGOFile(ctx, opts, []byte("some content"))
+		require.NoError(t, err)
+		require.Equal(t, prHTMLURL, pullRequestURL)
+		require.Equal(t, int32(1), refAttempts.Load())
+		require.Equal(t, int32(1), prAttempts.Load())
+	})
+
+	t.Run("when the PGO file does not exist, then the call is not retried", func(t *testing.T) {
+		t.Parallel()
+
+		var attempts atomic.Int32
+
+		mockedHTTPClient := mock.NewMockedHTTPClient(
+			mock.WithRequestMatchHandler(
+				mock.GetReposContentsByOwnerByRepoByPath,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					attempts.Add(1)
+
+					mock.WriteError(w, http.StatusNotFound, "not found")
+				}),
+			),
+		)
+
+		client := gh.NewClient(github.NewClient(mockedHTTPClient)).WithRetryPolicy(policy)
+
+		downloadURL, err := client.ExistingPGOFileURL(ctx, opts)
+		require.ErrorIs(t, err, gitops.ErrPGOFileNotFound)
+		require.Empty(t, downloadURL)
+		require.Equal(t, int32(1), attempts.Load())
+	})
+}
+
 func TestNewClientWithAccessToken(t *testing.T) {
 	ctx := context.Background()
 
@@ -0,0 +1,57 @@
+package gh
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/go-github/v53/github"
+
+	"github.com/macabu/cpgo/internal/retry"
+)
+
+// apiError classifies the errors of the GitHub API, so that only transient ones are retried.
+type apiError struct {
+	err        error
+	permanent  bool
+	retryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+func (e *apiError) Permanent() bool {
+	return e.permanent
+}
+
+func (e *apiError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// classifyError as transient for rate limits, server errors and network failures, otherwise as permanent.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var (
+		rateLimitErr      *github.RateLimitError
+		abuseRateLimitErr *github.AbuseRateLimitError
+		responseErr       *github.ErrorResponse
+	)
+
+	switch {
+	case errors.As(err, &rateLimitErr):
+		return &apiError{err: err, retryAfter: time.Until(rateLimitErr.Rate.Reset.Time)}
+	case errors.As(err, &abuseRateLimitErr):
+		return &apiError{err: err, retryAfter: abuseRateLimitErr.GetRetryAfter()}
+	case errors.As(err, &responseErr) && responseErr.Response != nil:
+		return &apiError{err: err, permanent: !retry.IsTransientStatus(responseErr.Response.StatusCode)}
+	default:
+		return &apiError{err: err}
+	}
+}
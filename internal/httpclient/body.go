@@ -0,0 +1,17 @@
+package httpclient
+
+import "strings"
+
+// DefaultMaxBodySize is well above the size of CPU profiles, while protecting against unbounded bodies.
+const DefaultMaxBodySize = 256 << 20
+
+// Snippet of the beginning of a body, made printable, e.g. to tell what a server responded with instead of a profile.
+func Snippet(body []byte) string {
+	const maxSnippetSize = 256
+
+	if len(body) > maxSnippetSize {
+		body = body[:maxSnippetSize]
+	}
+
+	return strings.ToValidUTF8(string(body), "")
+}
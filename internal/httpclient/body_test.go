@@ -0,0 +1,18 @@
+package httpclient_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/httpclient"
+)
+
+func TestSnippet(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "404 page not found", httpclient.Snippet([]byte("404 page not found")))
+	require.Len(t, httpclient.Snippet([]byte(strings.Repeat("a", 1024))), 256)
+	require.Equal(t, "profile", httpclient.Snippet([]byte("profile\xff")))
+}
@@ -0,0 +1,295 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type BasicAuth struct {
+	Username string
+	// Password, when it is not read from a file or the environment.
+	Password string
+	// PasswordFile is read on every request, so that the password can be rotated.
+	PasswordFile string
+	// PasswordEnv is the environment variable holding the password.
+	PasswordEnv string
+}
+
+type TLS struct {
+	// CAFile verifies the server certificate, instead of the system pool.
+	CAFile string
+	// CertFile and KeyFile are the client certificate presented to the server.
+	CertFile string
+	KeyFile  string
+	// CAPEM, CertPEM and KeyPEM are used instead of the files when set, e.g. when inlined in a kubeconfig.
+	CAPEM   []byte
+	CertPEM []byte
+	KeyPEM  []byte
+	// ServerName overrides the name used to verify the server certificate.
+	ServerName string
+	// InsecureSkipVerify disables the verification of the server certificate, only meant for non-production setups.
+	InsecureSkipVerify bool
+}
+
+type Options struct {
+	// BearerToken, when it is not read from a file or the environment.
+	BearerToken string
+	// BearerTokenFile is read on every request, so that the token can be rotated.
+	BearerTokenFile string
+	// BearerTokenEnv is the environment variable holding the token.
+	BearerTokenEnv string
+	BasicAuth      *BasicAuth
+	TLS            TLS
+	// Headers are set on every request.
+	Headers map[string]string
+	// Timeout of the whole request, including reading the body. Defaults to DefaultTimeout.
+	Timeout time.Duration
+	// ConnectTimeout of establishing the connection. Defaults to DefaultConnectTimeout.
+	ConnectTimeout time.Duration
+	// ProxyURL to send the requests through, defaults to the proxy from the environment.
+	ProxyURL string
+}
+
+const (
+	// DefaultTimeout leaves enough room for CPU profiles of a few minutes, while never hanging forever.
+	DefaultTimeout        = 5 * time.Minute
+	DefaultConnectTimeout = 30 * time.Second
+
+	tlsHandshakeTimeout = 10 * time.Second
+	// idleConnTimeout closes the connections kept alive between two runs of a backend that are far apart.
+	idleConnTimeout = 90 * time.Second
+)
+
+// New builds an HTTP client that authenticates every request according to the options. The credentials and headers
+// are not sent when redirected to another host, as they are meant for the one requested. The client is meant to be
+// reused, so that its connections are.
+func New(opts Options) (*http.Client, error) {
+	transport, err := newTransport(opts)
+	if err != nil {
+		return nil, fmt.Errorf("newTransport: %w", err)
+	}
+
+	auth := authRoundTripper{
+		bearerToken:     opts.BearerToken,
+		bearerTokenFile: opts.BearerTokenFile,
+		basicAuth:       opts.BasicAuth,
+		headers:         opts.Headers,
+		next:            transport,
+	}
+
+	if opts.BasicAuth != nil {
+		auth.password = opts.BasicAuth.Password
+	}
+
+	if opts.BearerTokenEnv != "" {
+		auth.bearerToken, err = readEnv(opts.BearerTokenEnv)
+		if err != nil {
+			return nil, fmt.Errorf("bearer token: %w", err)
+		}
+	}
+
+	if opts.BasicAuth != nil && opts.BasicAuth.PasswordEnv != "" {
+		auth.password, err = readEnv(opts.BasicAuth.PasswordEnv)
+		if err != nil {
+			return nil, fmt.Errorf("basic auth password: %w", err)
+		}
+	}
+
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &http.Client{Transport: auth, Timeout: timeout}, nil
+}
+
+// newTransport with the connection, proxy and TLS settings.
+func newTransport(opts Options) (*http.Transport, error) {
+	tlsConfig, err := newTLSConfig(opts.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("newTLSConfig: %w", err)
+	}
+
+	proxy := http.ProxyFromEnvironment
+
+	if opts.ProxyURL != "" {
+		proxyURL, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("url.Parse: %w", err)
+		}
+
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	connectTimeout := opts.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+
+	dialer := &net.Dialer{Timeout: connectTimeout}
+
+	return &http.Transport{
+		Proxy:               proxy,
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: tlsHandshakeTimeout,
+		IdleConnTimeout:     idleConnTimeout,
+		ForceAttemptHTTP2:   true,
+	}, nil
+}
+
+// newTLSConfig loads the custom CA and client certificate, if any.
+func newTLSConfig(opts TLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	caPEM := opts.CAPEM
+
+	if caPEM == nil && opts.CAFile != "" {
+		var err error
+
+		caPEM, err = os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("os.ReadFile: %w", err)
+		}
+	}
+
+	if caPEM != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, ErrInvalidCertificateAuthority
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if (opts.CertFile == "") != (opts.KeyFile == "") || (opts.CertPEM == nil) != (opts.KeyPEM == nil) {
+		return nil, ErrIncompleteClientCertificate
+	}
+
+	switch {
+	case opts.CertPEM != nil:
+		cert, err := tls.X509KeyPair(opts.CertPEM, opts.KeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("tls.X509KeyPair: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case opts.CertFile != "":
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls.LoadX509KeyPair: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func readEnv(name string) (string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return "", fmt.Errorf("%v: %w", name, ErrEnvNotSet)
+	}
+
+	return value, nil
+}
+
+func readFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+// authRoundTripper sets the credentials and headers on every request to the host originally requested.
+type authRoundTripper struct {
+	bearerToken     string
+	bearerTokenFile string
+	basicAuth       *BasicAuth
+	password        string
+	headers         map[string]string
+	next            http.RoundTripper
+}
+
+func (a authRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	// As done by http.Client for its own headers, nothing is leaked to the hosts the requested one redirects to.
+	if !isOriginalHost(r) {
+		return a.next.RoundTrip(r)
+	}
+
+	token, password, err := a.credentials()
+	if err != nil {
+		// The transport is not called, which would otherwise close the body.
+		if r.Body != nil {
+			r.Body.Close()
+		}
+
+		return nil, err
+	}
+
+	r = r.Clone(r.Context())
+
+	for k, v := range a.headers {
+		r.Header.Set(k, v)
+	}
+
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	if a.basicAuth != nil {
+		r.SetBasicAuth(a.basicAuth.Username, password)
+	}
+
+	return a.next.RoundTrip(r)
+}
+
+// credentials returns the bearer token and basic auth password, reading the latest ones from their files if any.
+func (a authRoundTripper) credentials() (string, string, error) {
+	token, password := a.bearerToken, a.password
+
+	if a.bearerTokenFile != "" {
+		fileToken, err := readFile(a.bearerTokenFile)
+		if err != nil {
+			return "", "", fmt.Errorf("bearer token: %w", err)
+		}
+
+		token = fileToken
+	}
+
+	if a.basicAuth != nil && a.basicAuth.PasswordFile != "" {
+		filePassword, err := readFile(a.basicAuth.PasswordFile)
+		if err != nil {
+			return "", "", fmt.Errorf("basic auth password: %w", err)
+		}
+
+		password = filePassword
+	}
+
+	return token, password, nil
+}
+
+// isOriginalHost reports whether the request goes to the same host as the first request of its redirect chain.
+func isOriginalHost(r *http.Request) bool {
+	original := r
+
+	for original.Response != nil && original.Response.Request != nil {
+		original = original.Response.Request
+	}
+
+	return original.URL.Host == r.URL.Host
+}
@@ -0,0 +1,393 @@
+package httpclient_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/httpclient"
+)
+
+// newCertificate issues a certificate for localhost, self-signed when `parent` is nil. Returns the certificate and its PEMs.
+func newCertificate(t *testing.T, parent *tls.Certificate) (tls.Certificate, []byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"localhost"},
+	}
+
+	issuer, signer := template, any(key)
+
+	if parent != nil {
+		issuer = parent.Leaf
+		signer = parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	cert.Leaf, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return cert, certPEM, keyPEM
+}
+
+func writeFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, content, 0o600))
+
+	return path
+}
+
+// closeRecorder records whether the body was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+
+	return nil
+}
+
+// get requests the `url` with the client, failing only when the request could not be made.
+func get(t *testing.T, client *http.Client, url string) error {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	t.Run("given a bearer token file, basic auth and headers, then they are set on every request", func(t *testing.T) {
+		t.Parallel()
+
+		var seen *http.Request
+
+		srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			seen = r
+		}))
+		t.Cleanup(srv.Close)
+
+		tokenFile := writeFile(t, "token", []byte("my-token\n"))
+
+		client, err := httpclient.New(httpclient.Options{
+			BearerTokenFile: tokenFile,
+			Headers:         map[string]string{"X-Scope-OrgID": "checkout"},
+		})
+		require.NoError(t, err)
+
+		err = get(t, client, srv.URL)
+		require.NoError(t, err)
+		require.Equal(t, "Bearer my-token", seen.Header.Get("Authorization"))
+		require.Equal(t, "checkout", seen.Header.Get("X-Scope-OrgID"))
+
+		require.NoError(t, os.WriteFile(tokenFile, []byte("rotated-token"), 0o600))
+
+		err = get(t, client, srv.URL)
+		require.NoError(t, err)
+		require.Equal(t, "Bearer rotated-token", seen.Header.Get("Authorization"))
+
+		client, err = httpclient.New(httpclient.Options{
+			BasicAuth: &httpclient.BasicAuth{
+				Username:     "cpgo",
+				PasswordFile: writeFile(t, "password", []byte("hunter2")),
+			},
+		})
+		require.NoError(t, err)
+
+		err = get(t, client, srv.URL)
+		require.NoError(t, err)
+
+		username, password, ok := seen.BasicAuth()
+		require.True(t, ok)
+		require.Equal(t, "cpgo", username)
+		require.Equal(t, "hunter2", password)
+	})
+
+	t.Run("given a client certificate and a custom CA, then it authenticates with mutual TLS", func(t *testing.T) {
+		t.Parallel()
+
+		ca, caPEM, _ := newCertificate(t, nil)
+		serverCert, _, _ := newCertificate(t, &ca)
+		_, clientCertPEM, clientKeyPEM := newCertificate(t, &ca)
+
+		pool := x509.NewCertPool()
+		pool.AddCert(ca.Leaf)
+
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		srv.TLS = &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{serverCert},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		}
+		srv.StartTLS()
+		t.Cleanup(srv.Close)
+
+		client, err := httpclient.New(httpclient.Options{
+			TLS: httpclient.TLS{
+				CAFile:   writeFile(t, "ca.crt", caPEM),
+				CertFile: writeFile(t, "client.crt", clientCertPEM),
+				KeyFile:  writeFile(t, "client.key", clientKeyPEM),
+			},
+		})
+		require.NoError(t, err)
+
+		err = get(t, client, srv.URL)
+		require.NoError(t, err)
+
+		clientWithoutCert, err := httpclient.New(httpclient.Options{
+			TLS: httpclient.TLS{CAFile: writeFile(t, "ca.crt", caPEM)},
+		})
+		require.NoError(t, err)
+
+		err = get(t, clientWithoutCert, srv.URL)
+		require.Error(t, err)
+	})
+
+	t.Run("given inlined PEMs, then they are used instead of files", func(t *testing.T) {
+		t.Parallel()
+
+		ca, caPEM, _ := newCertificate(t, nil)
+		serverCert, _, _ := newCertificate(t, &ca)
+		_, clientCertPEM, clientKeyPEM := newCertificate(t, &ca)
+
+		pool := x509.NewCertPool()
+		pool.AddCert(ca.Leaf)
+
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		srv.TLS = &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{serverCert},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		}
+		srv.StartTLS()
+		t.Cleanup(srv.Close)
+
+		client, err := httpclient.New(httpclient.Options{
+			TLS: httpclient.TLS{CAPEM: caPEM, CertPEM: clientCertPEM, KeyPEM: clientKeyPEM},
+		})
+		require.NoError(t, err)
+		require.NoError(t, get(t, client, srv.URL))
+	})
+
+	t.Run("when redirected to another host, then the credentials and headers are not sent there", func(t *testing.T) {
+		t.Parallel()
+
+		var seen *http.Request
+
+		other := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			seen = r
+		}))
+		t.Cleanup(other.Close)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/same-host" {
+				http.Redirect(w, r, "/landing", http.StatusFound)
+
+				return
+			}
+
+			if r.URL.Path == "/landing" {
+				seen = r
+
+				return
+			}
+
+			http.Redirect(w, r, other.URL, http.StatusFound)
+		}))
+		t.Cleanup(srv.Close)
+
+		client, err := httpclient.New(httpclient.Options{
+			BearerToken: "my-token",
+			BasicAuth:   &httpclient.BasicAuth{Username: "cpgo", Password: "hunter2"},
+			Headers:     map[string]string{"X-Scope-OrgID": "checkout"},
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, get(t, client, srv.URL+"/other-host"))
+		require.Empty(t, seen.Header.Get("Authorization"))
+		require.Empty(t, seen.Header.Get("X-Scope-OrgID"))
+
+		require.NoError(t, get(t, client, srv.URL+"/same-host"))
+		require.NotEmpty(t, seen.Header.Get("Authorization"))
+		require.Equal(t, "checkout", seen.Header.Get("X-Scope-OrgID"))
+	})
+
+	t.Run("when the credentials cannot be read, then the request body is closed", func(t *testing.T) {
+		t.Parallel()
+
+		client, err := httpclient.New(httpclient.Options{BearerTokenFile: filepath.Join(t.TempDir(), "missing")})
+		require.NoError(t, err)
+
+		body := &closeRecorder{Reader: strings.NewReader("profile")}
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost", body)
+		require.NoError(t, err)
+
+		_, err = client.Transport.RoundTrip(req)
+		require.Error(t, err)
+		require.True(t, body.closed)
+	})
+
+	t.Run("when the server takes longer than the timeout, the request fails", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}))
+		t.Cleanup(srv.Close)
+
+		client, err := httpclient.New(httpclient.Options{Timeout: 10 * time.Millisecond})
+		require.NoError(t, err)
+
+		err = get(t, client, srv.URL)
+		require.Error(t, err)
+	})
+
+	t.Run("given a proxy URL, then the requests are sent through it", func(t *testing.T) {
+		t.Parallel()
+
+		var proxied string
+
+		proxy := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			proxied = r.URL.String()
+		}))
+		t.Cleanup(proxy.Close)
+
+		client, err := httpclient.New(httpclient.Options{ProxyURL: proxy.URL})
+		require.NoError(t, err)
+
+		err = get(t, client, "http://checkout.internal:6060/debug/pprof/profile")
+		require.NoError(t, err)
+		require.Equal(t, "http://checkout.internal:6060/debug/pprof/profile", proxied)
+	})
+
+	t.Run("given a TLS server name or skipping verification, then the server certificate is checked accordingly", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(srv.Close)
+
+		caFile := writeFile(t, "ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+
+		client, err := httpclient.New(httpclient.Options{TLS: httpclient.TLS{CAFile: caFile, ServerName: "example.com"}})
+		require.NoError(t, err)
+		require.NoError(t, get(t, client, srv.URL))
+
+		client, err = httpclient.New(httpclient.Options{TLS: httpclient.TLS{CAFile: caFile, ServerName: "checkout.internal"}})
+		require.NoError(t, err)
+		require.Error(t, get(t, client, srv.URL))
+
+		client, err = httpclient.New(httpclient.Options{TLS: httpclient.TLS{InsecureSkipVerify: true}})
+		require.NoError(t, err)
+		require.NoError(t, get(t, client, srv.URL))
+	})
+
+	t.Run("when the proxy URL is invalid, an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		client, err := httpclient.New(httpclient.Options{ProxyURL: string([]byte{0x7f})})
+		require.Error(t, err)
+		require.Nil(t, client)
+	})
+
+	t.Run("when only the client certificate is given, an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		client, err := httpclient.New(httpclient.Options{TLS: httpclient.TLS{CertFile: "client.crt"}})
+		require.ErrorIs(t, err, httpclient.ErrIncompleteClientCertificate)
+		require.Nil(t, client)
+	})
+
+	t.Run("when the CA file has no certificates, an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		client, err := httpclient.New(httpclient.Options{TLS: httpclient.TLS{CAFile: writeFile(t, "ca.crt", []byte("nope"))}})
+		require.ErrorIs(t, err, httpclient.ErrInvalidCertificateAuthority)
+		require.Nil(t, client)
+	})
+}
+
+func TestNewWithEnv(t *testing.T) {
+	t.Setenv("CPGO_TEST_TOKEN", "token-from-env")
+
+	var seen *http.Request
+
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		seen = r
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := httpclient.New(httpclient.Options{BearerTokenEnv: "CPGO_TEST_TOKEN"})
+	require.NoError(t, err)
+
+	err = get(t, client, srv.URL)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer token-from-env", seen.Header.Get("Authorization"))
+
+	client, err = httpclient.New(httpclient.Options{BearerTokenEnv: "CPGO_TEST_TOKEN_NOT_SET"})
+	require.ErrorIs(t, err, httpclient.ErrEnvNotSet)
+	require.Nil(t, client)
+}
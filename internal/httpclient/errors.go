@@ -0,0 +1,10 @@
+package httpclient
+
+import "errors"
+
+var (
+	ErrBodyTooLarge                = errors.New("response body exceeds the maximum size")
+	ErrEnvNotSet                   = errors.New("environment variable is not set or empty")
+	ErrInvalidCertificateAuthority = errors.New("could not parse any certificate from the certificate authority")
+	ErrIncompleteClientCertificate = errors.New("both a client certificate and key must be provided")
+)
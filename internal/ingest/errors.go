@@ -0,0 +1,10 @@
+package ingest
+
+import "errors"
+
+var (
+	ErrNoToken       = errors.New("no token configured for the backend")
+	ErrUnknownInbox  = errors.New("no inbox for the backend")
+	ErrInvalidToken  = errors.New("missing or invalid bearer token")
+	ErrInvalidUpload = errors.New("upload is not a CPU profile")
+)
@@ -0,0 +1,161 @@
+package ingest
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/macabu/cpgo/internal/pprof"
+	"github.com/macabu/cpgo/internal/store"
+)
+
+// UploadPath receives the profiles of the backend with the given ID, in the pprof format.
+const UploadPath = "/api/v1/backends/{id}/profiles"
+
+// Inbox buffers the profiles pushed for a backend, until they are merged on its schedule.
+type Inbox struct {
+	// The bearer token expected from the clients is read on every request, from a file or an environment variable.
+	TokenFile string
+	TokenEnv  string
+	Store     *store.Store
+}
+
+type Options struct {
+	// MaxBodySize in bytes of an upload, defaults to pprof.DefaultMaxBodySize.
+	MaxBodySize int64
+	// Logger for the failures that are not caused by the clients, discarded by default.
+	Logger zerolog.Logger
+}
+
+// Server accepts the profiles pushed by the workloads that cannot be scraped, e.g. serverless functions.
+type Server struct {
+	inboxes map[string]Inbox
+	opts    Options
+	mux     *http.ServeMux
+}
+
+// NewServer with the inboxes by backend ID.
+func NewServer(inboxes map[string]Inbox, opts Options) *Server {
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = pprof.DefaultMaxBodySize
+	}
+
+	s := &Server{
+		inboxes: inboxes,
+		opts:    opts,
+		mux:     http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc(http.MethodPost+" "+UploadPath, s.upload)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// upload validates the pushed profile and buffers it in the inbox of the backend.
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	logger := s.opts.Logger.With().Str("backend_id", id).Str("remote_addr", r.RemoteAddr).Logger()
+
+	inbox, ok := s.inboxes[id]
+	if !ok {
+		http.Error(w, ErrUnknownInbox.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	if err := authenticate(r, inbox); err != nil {
+		if !errors.Is(err, ErrInvalidToken) {
+			logger.Error().Err(err).Msg("Failed to read the token of the backend")
+		}
+
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, ErrInvalidToken.Error(), http.StatusUnauthorized)
+
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.opts.MaxBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, pprof.ErrBodyTooLarge.Error(), http.StatusRequestEntityTooLarge)
+
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if _, err := pprof.ParseCPUProfile(data); err != nil {
+		http.Error(w, fmt.Sprintf("%v: %v", ErrInvalidUpload, err), http.StatusBadRequest)
+
+		return
+	}
+
+	name, err := inbox.Store.Put(data)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to buffer the pushed profile")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	logger.Debug().Str("name", name).Int("size", len(data)).Msg("Buffered pushed profile")
+
+	// Accepted rather than created, as the profile is only merged on the next run of the backend.
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// authenticate the request against the token of the inbox, in constant time.
+func authenticate(r *http.Request, inbox Inbox) error {
+	expected, err := readToken(inbox)
+	if err != nil {
+		return err
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// readToken of the inbox, from the file first and then the environment variable.
+func readToken(inbox Inbox) (string, error) {
+	var token string
+
+	switch {
+	case inbox.TokenFile != "":
+		content, err := os.ReadFile(inbox.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("os.ReadFile: %w", err)
+		}
+
+		token = string(content)
+	case inbox.TokenEnv != "":
+		token = os.Getenv(inbox.TokenEnv)
+	}
+
+	token = strings.TrimSpace(token)
+
+	// An empty token would let anyone push profiles.
+	if token == "" {
+		return "", ErrNoToken
+	}
+
+	return token, nil
+}
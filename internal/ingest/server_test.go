@@ -0,0 +1,147 @@
+package ingest_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/ingest"
+	"github.com/macabu/cpgo/internal/store"
+)
+
+func encodeProfile(t *testing.T, sampleType *profile.ValueType) []byte {
+	t.Helper()
+
+	fn := &profile.Function{ID: 1, Name: "main.main"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{sampleType},
+		PeriodType: sampleType,
+		Period:     1,
+		Sample:     []*profile.Sample{{Location: []*profile.Location{loc}, Value: []int64{1}}},
+		Location:   []*profile.Location{loc},
+		Function:   []*profile.Function{fn},
+	}
+
+	var buf bytes.Buffer
+
+	require.NoError(t, prof.Write(&buf))
+
+	return buf.Bytes()
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	cpuProfile := encodeProfile(t, &profile.ValueType{Type: "samples", Unit: "count"})
+	heapProfile := encodeProfile(t, &profile.ValueType{Type: "alloc_space", Unit: "bytes"})
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0o600))
+
+	testcases := []struct {
+		name           string
+		path           string
+		token          string
+		body           []byte
+		inbox          ingest.Inbox
+		expectedStatus int
+		expectedStored int
+	}{
+		{
+			name:           "given a valid upload, then it is buffered in the inbox of the backend",
+			path:           "/api/v1/backends/checkout/profiles",
+			token:          "secret",
+			body:           cpuProfile,
+			inbox:          ingest.Inbox{TokenFile: tokenFile},
+			expectedStatus: http.StatusAccepted,
+			expectedStored: 1,
+		},
+		{
+			name:           "when the token is invalid, then the upload is rejected",
+			path:           "/api/v1/backends/checkout/profiles",
+			token:          "not-the-secret",
+			body:           cpuProfile,
+			inbox:          ingest.Inbox{TokenFile: tokenFile},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "when the backend has no token, then every upload is rejected",
+			path:           "/api/v1/backends/checkout/profiles",
+			token:          "",
+			body:           cpuProfile,
+			inbox:          ingest.Inbox{},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "when the backend does not exist, then the upload is rejected",
+			path:           "/api/v1/backends/payments/profiles",
+			token:          "secret",
+			body:           cpuProfile,
+			inbox:          ingest.Inbox{TokenFile: tokenFile},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "when the upload is not a CPU profile, then it is rejected",
+			path:           "/api/v1/backends/checkout/profiles",
+			token:          "secret",
+			body:           heapProfile,
+			inbox:          ingest.Inbox{TokenFile: tokenFile},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "when the upload is too large, then it is rejected",
+			path:           "/api/v1/backends/checkout/profiles",
+			token:          "secret",
+			body:           append(cpuProfile, make([]byte, 1024)...),
+			inbox:          ingest.Inbox{TokenFile: tokenFile},
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range testcases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := store.New(t.TempDir())
+			require.NoError(t, err)
+
+			tt.inbox.Store = s
+
+			srv := ingest.NewServer(map[string]ingest.Inbox{"checkout": tt.inbox}, ingest.Options{MaxBodySize: 512})
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			rec := httptest.NewRecorder()
+
+			srv.ServeHTTP(rec, req)
+			require.Equal(t, tt.expectedStatus, rec.Code, rec.Body.String())
+
+			entries, err := s.List()
+			require.NoError(t, err)
+			require.Len(t, entries, tt.expectedStored)
+		})
+	}
+
+	t.Run("when the method is not POST, then it is not allowed", func(t *testing.T) {
+		t.Parallel()
+
+		srv := ingest.NewServer(nil, ingest.Options{})
+
+		rec := httptest.NewRecorder()
+
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/backends/checkout/profiles", strings.NewReader("")))
+		require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}
@@ -0,0 +1,136 @@
+package pprof
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// canonicalize the profile, so that the same samples are always encoded into the same bytes whatever the order they
+// were merged in: the functions, mappings, locations and samples are sorted and renumbered, and the timestamp and
+// comments, which differ on every run, are dropped. Then an update that changes nothing has the same hash, and the
+// diffs of the PRs only show what changed.
+func canonicalize(prof *profile.Profile) {
+	prof.TimeNanos = 0
+	prof.Comments = nil
+
+	slices.SortFunc(prof.Function, func(a, b *profile.Function) int {
+		return cmp.Or(
+			cmp.Compare(a.Name, b.Name),
+			cmp.Compare(a.SystemName, b.SystemName),
+			cmp.Compare(a.Filename, b.Filename),
+			cmp.Compare(a.StartLine, b.StartLine),
+		)
+	})
+
+	for i, fn := range prof.Function {
+		fn.ID = uint64(i + 1)
+	}
+
+	slices.SortFunc(prof.Mapping, func(a, b *profile.Mapping) int {
+		return cmp.Or(
+			cmp.Compare(a.Start, b.Start),
+			cmp.Compare(a.Limit, b.Limit),
+			cmp.Compare(a.Offset, b.Offset),
+			cmp.Compare(a.File, b.File),
+			cmp.Compare(a.BuildID, b.BuildID),
+		)
+	})
+
+	for i, mapping := range prof.Mapping {
+		mapping.ID = uint64(i + 1)
+	}
+
+	slices.SortFunc(prof.Location, compareLocations)
+
+	for i, loc := range prof.Location {
+		loc.ID = uint64(i + 1)
+	}
+
+	slices.SortFunc(prof.Sample, func(a, b *profile.Sample) int {
+		return cmp.Or(
+			slices.CompareFunc(a.Location, b.Location, func(a, b *profile.Location) int {
+				return cmp.Compare(a.ID, b.ID)
+			}),
+			cmp.Compare(labelsKey(a.Label), labelsKey(b.Label)),
+			cmp.Compare(labelsKey(a.NumLabel), labelsKey(b.NumLabel)),
+			slices.Compare(a.Value, b.Value),
+		)
+	})
+}
+
+// compareLocations by their lines, which are already renumbered, and then by their address.
+func compareLocations(a, b *profile.Location) int {
+	mappingID := func(loc *profile.Location) uint64 {
+		if loc.Mapping == nil {
+			return 0
+		}
+
+		return loc.Mapping.ID
+	}
+
+	functionID := func(line profile.Line) uint64 {
+		if line.Function == nil {
+			return 0
+		}
+
+		return line.Function.ID
+	}
+
+	return cmp.Or(
+		slices.CompareFunc(a.Line, b.Line, func(a, b profile.Line) int {
+			return cmp.Or(
+				cmp.Compare(functionID(a), functionID(b)),
+				cmp.Compare(a.Line, b.Line),
+			)
+		}),
+		cmp.Compare(mappingID(a), mappingID(b)),
+		cmp.Compare(a.Address, b.Address),
+		compareBool(a.IsFolded, b.IsFolded),
+	)
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// labelsKey returns the labels as a string with sorted keys, e.g. `handler=a,b;method=GET`, to compare them.
+func labelsKey[V any](labels map[string][]V) string {
+	keys := make([]string, 0, len(labels))
+
+	for k := range labels {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var b strings.Builder
+
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+
+		for i, v := range labels[k] {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+
+			b.WriteString(fmt.Sprint(v))
+		}
+
+		b.WriteByte(';')
+	}
+
+	return b.String()
+}
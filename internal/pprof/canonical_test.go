@@ -0,0 +1,89 @@
+package pprof_test
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/pprof"
+)
+
+func TestMergeProfilesDeterminism(t *testing.T) {
+	t.Parallel()
+
+	newProfiles := func(t *testing.T) (*profile.Profile, *profile.Profile) {
+		t.Helper()
+
+		first := newCPUProfile(t,
+			stack{frames: []string{"main.main", "main.a"}, value: 5, labels: map[string][]string{"handler": {"a"}, "method": {"GET"}}},
+			stack{frames: []string{"main.main", "main.b"}, value: 7},
+		)
+		second := newCPUProfile(t,
+			stack{frames: []string{"main.main", "main.c"}, value: 3},
+			stack{frames: []string{"main.main", "main.a"}, value: 2},
+		)
+
+		second.TimeNanos = first.TimeNanos + 1
+		second.Comments = []string{"scraped at 12:00"}
+
+		// The same profile, with its functions and locations numbered the other way around.
+		slices.Reverse(second.Function)
+		slices.Reverse(second.Location)
+
+		for i, fn := range second.Function {
+			fn.ID = uint64(i + 1)
+		}
+
+		for i, loc := range second.Location {
+			loc.ID = uint64(i + 1)
+		}
+
+		return first, second
+	}
+
+	merge := func(t *testing.T, profiles ...*profile.Profile) []byte {
+		t.Helper()
+
+		var w bytes.Buffer
+
+		require.NoError(t, pprof.MergeProfiles(&w, nil, profiles, pprof.MergeOptions{}))
+
+		return w.Bytes()
+	}
+
+	first, second := newProfiles(t)
+	merged := merge(t, first, second)
+
+	t.Run("given the same profiles in another order, then the output is the same bytes", func(t *testing.T) {
+		t.Parallel()
+
+		first, second := newProfiles(t)
+
+		require.Equal(t, merged, merge(t, second, first))
+	})
+
+	t.Run("given the merged profile, then it has no timestamp nor comments", func(t *testing.T) {
+		t.Parallel()
+
+		prof, err := profile.ParseData(merged)
+		require.NoError(t, err)
+		require.Zero(t, prof.TimeNanos)
+		require.Empty(t, prof.Comments)
+		require.Len(t, prof.Sample, 4)
+	})
+
+	t.Run("given the merged profile merged again, then it is the same bytes", func(t *testing.T) {
+		t.Parallel()
+
+		prof, err := profile.ParseData(merged)
+		require.NoError(t, err)
+
+		var w bytes.Buffer
+
+		require.NoError(t, pprof.MergeProfiles(&w, prof, nil, pprof.MergeOptions{}))
+		require.Equal(t, merged, w.Bytes())
+	})
+}
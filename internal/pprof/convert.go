@@ -0,0 +1,333 @@
+package pprof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// DefaultConvertPeriod matches the 100Hz sampling rate of the Go runtime.
+const DefaultConvertPeriod = 10 * time.Millisecond
+
+// unknownSymbol names the frames without a symbol, as perf does.
+const unknownSymbol = "[unknown]"
+
+// maxLineSize bounds the lines of the converted formats, deep stacks make for long folded lines.
+const maxLineSize = 1 << 20
+
+type ConvertOptions struct {
+	// Period between two samples, used as the CPU time of each sample when the input does not have it.
+	// Defaults to DefaultConvertPeriod.
+	Period time.Duration
+}
+
+// ParseFolded converts the collapsed stacks of Brendan Gregg's FlameGraph scripts, e.g. stackcollapse-perf.pl,
+// into a CPU profile usable for PGO. Each line is a stack, from the root to the leaf, followed by its sample count:
+//
+//	main.main;main.handle;runtime.mallocgc 42
+func ParseFolded(r io.Reader, opts ConvertOptions) (*profile.Profile, error) {
+	b := newStackBuilder(opts)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.LastIndexAny(line, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("line %v: %w: missing sample count", lineno, ErrUnparsableProfile)
+		}
+
+		count, err := strconv.ParseInt(line[i+1:], 10, 64)
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("line %v: %w: invalid sample count %q", lineno, ErrUnparsableProfile, line[i+1:])
+		}
+
+		frames := strings.Split(strings.TrimSpace(line[:i]), ";")
+
+		// Folded stacks go from the root to the leaf, while pprof samples go from the leaf to the root.
+		for l, r := 0, len(frames)-1; l < r; l, r = l+1, r-1 {
+			frames[l], frames[r] = frames[r], frames[l]
+		}
+
+		b.add(frames, count, count*b.period)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanner.Err: %w", err)
+	}
+
+	return b.build()
+}
+
+// ParsePerfScript converts the output of `perf script` into a CPU profile usable for PGO. Each sample is a header line
+// followed by its call chain, from the leaf to the root, and a blank line:
+//
+//	checkout 1234 [001] 12345.678901:     250000 cpu-clock:pppH:
+//		  4a1b2c main.handle+0x2c (/usr/bin/checkout)
+//		  4a0f10 main.main+0x10 (/usr/bin/checkout)
+//
+// The period of the cpu-clock and task-clock events is their CPU time, other events use ConvertOptions.Period instead.
+func ParsePerfScript(r io.Reader, opts ConvertOptions) (*profile.Profile, error) {
+	b := newStackBuilder(opts)
+
+	var (
+		frames   []string
+		nanos    int64
+		inSample bool
+	)
+
+	flush := func() {
+		if inSample && len(frames) > 0 {
+			b.add(frames, 1, nanos)
+		}
+
+		frames, inSample = nil, false
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+		case strings.HasPrefix(line, "#"):
+			// Header comments of `perf script --header`.
+		case line[0] != ' ' && line[0] != '\t':
+			flush()
+
+			sampleNanos, err := parsePerfHeader(line, b.period)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %w: %w", lineno, ErrUnparsableProfile, err)
+			}
+
+			nanos, inSample = sampleNanos, true
+		case inSample:
+			frames = append(frames, parsePerfFrame(line))
+		}
+	}
+
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanner.Err: %w", err)
+	}
+
+	return b.build()
+}
+
+// parsePerfHeader returns the CPU time of the sample, from its period when the event counts nanoseconds.
+func parsePerfHeader(line string, defaultNanos int64) (int64, error) {
+	fields := strings.Fields(line)
+
+	// The command may have spaces, so the fields are found from the timestamp, e.g. `12345.678901:`.
+	for i, field := range fields {
+		timestamp, ok := strings.CutSuffix(field, ":")
+		if !ok {
+			continue
+		}
+
+		if _, err := strconv.ParseFloat(timestamp, 64); err != nil {
+			continue
+		}
+
+		rest := fields[i+1:]
+
+		// The period is only printed for some events, and precedes the event name.
+		if len(rest) < 2 {
+			return defaultNanos, nil
+		}
+
+		period, err := strconv.ParseInt(rest[0], 10, 64)
+		if err != nil {
+			return defaultNanos, nil
+		}
+
+		event, _, _ := strings.Cut(rest[1], ":")
+		if event == "cpu-clock" || event == "task-clock" {
+			return period, nil
+		}
+
+		return defaultNanos, nil
+	}
+
+	return 0, errors.New("missing timestamp in sample header")
+}
+
+// parsePerfFrame returns the symbol of a call chain line, e.g. `4a1b2c main.handle+0x2c (/usr/bin/checkout)`.
+func parsePerfFrame(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return unknownSymbol
+	}
+
+	// Skips the address, and the binary in parentheses if any.
+	fields = fields[1:]
+	if last := fields[len(fields)-1]; strings.HasPrefix(last, "(") && strings.HasSuffix(last, ")") {
+		fields = fields[:len(fields)-1]
+	}
+
+	symbol := strings.Join(fields, " ")
+
+	if i := strings.LastIndex(symbol, "+0x"); i > 0 {
+		symbol = symbol[:i]
+	}
+
+	if symbol == "" {
+		return unknownSymbol
+	}
+
+	return symbol
+}
+
+// convertedStartLine of the functions of the converted profiles. The Go compiler rejects the profiles without start
+// lines, and the converted formats have no line numbers, so every function starts and is called at this line.
+const convertedStartLine = 1
+
+// stackBuilder builds a CPU profile out of stacks of function names, aggregating identical stacks.
+// The converted formats have no line numbers, so every function has a single location, see convertedStartLine.
+type stackBuilder struct {
+	prof      *profile.Profile
+	period    int64
+	functions map[string]*profile.Function
+	locations map[string]*profile.Location
+	// callSites are the locations of the calls in the converted preprocessed profiles, see addEdge.
+	callSites map[callSite]*profile.Location
+	samples   map[string]*profile.Sample
+}
+
+type callSite struct {
+	function string
+	offset   int64
+}
+
+func newStackBuilder(opts ConvertOptions) *stackBuilder {
+	period := opts.Period
+	if period <= 0 {
+		period = DefaultConvertPeriod
+	}
+
+	return &stackBuilder{
+		prof: &profile.Profile{
+			// The sample types of the Go runtime CPU profiles, which the Go compiler looks for.
+			SampleType: []*profile.ValueType{
+				{Type: "samples", Unit: "count"},
+				{Type: "cpu", Unit: "nanoseconds"},
+			},
+			PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+			Period:     period.Nanoseconds(),
+		},
+		period:    period.Nanoseconds(),
+		functions: make(map[string]*profile.Function),
+		locations: make(map[string]*profile.Location),
+		callSites: make(map[callSite]*profile.Location),
+		samples:   make(map[string]*profile.Sample),
+	}
+}
+
+// add a stack of frames from the leaf to the root, with its values.
+func (b *stackBuilder) add(frames []string, count, nanos int64) {
+	key := strings.Join(frames, ";")
+
+	if sample, ok := b.samples[key]; ok {
+		sample.Value[0] += count
+		sample.Value[1] += nanos
+
+		return
+	}
+
+	sample := &profile.Sample{Value: []int64{count, nanos}}
+
+	for _, name := range frames {
+		sample.Location = append(sample.Location, b.location(strings.TrimSpace(name)))
+	}
+
+	b.samples[key] = sample
+	b.prof.Sample = append(b.prof.Sample, sample)
+}
+
+// location of the function with the given name, added to the profile the first time.
+func (b *stackBuilder) location(name string) *profile.Location {
+	if name == "" {
+		name = unknownSymbol
+	}
+
+	if loc, ok := b.locations[name]; ok {
+		return loc
+	}
+
+	loc := &profile.Location{
+		ID:   uint64(len(b.prof.Location) + 1),
+		Line: []profile.Line{{Function: b.function(name), Line: convertedStartLine}},
+	}
+	b.locations[name] = loc
+	b.prof.Location = append(b.prof.Location, loc)
+
+	return loc
+}
+
+// function with the given name, added to the profile the first time.
+func (b *stackBuilder) function(name string) *profile.Function {
+	if fn, ok := b.functions[name]; ok {
+		return fn
+	}
+
+	fn := &profile.Function{ID: uint64(len(b.functions) + 1), Name: name, SystemName: name, StartLine: convertedStartLine}
+	b.functions[name] = fn
+	b.prof.Function = append(b.prof.Function, fn)
+
+	return fn
+}
+
+// build the profile, which must have at least a sample.
+func (b *stackBuilder) build() (*profile.Profile, error) {
+	if len(b.prof.Sample) == 0 {
+		return nil, fmt.Errorf("%w: no samples", ErrUnparsableProfile)
+	}
+
+	if err := b.prof.CheckValid(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnparsableProfile, err)
+	}
+
+	return b.prof, nil
+}
+
+// Format of the profiles loaded by the sources.
+type Format string
+
+const (
+	FormatPprof      Format = "pprof"
+	FormatFolded     Format = "folded"
+	FormatPerfScript Format = "perf_script"
+	FormatPreprofile Format = "preprofile"
+)
+
+// Parse the profile in `data`, which must be a CPU profile usable for PGO. An empty format defaults to FormatPprof.
+func (f Format) Parse(data []byte) (*profile.Profile, error) {
+	switch f {
+	case "", FormatPprof:
+		return ParseCPUProfile(data)
+	case FormatFolded:
+		return ParseFolded(bytes.NewReader(data), ConvertOptions{})
+	case FormatPerfScript:
+		return ParsePerfScript(bytes.NewReader(data), ConvertOptions{})
+	case FormatPreprofile:
+		return ParsePreprofile(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("%v: %w", f, ErrUnsupportedFormat)
+	}
+}
@@ -0,0 +1,200 @@
+package pprof_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/pprof"
+)
+
+// stacks returns the stacks of the profile from the root to the leaf, with their values.
+func stacks(prof *profile.Profile) map[string][]int64 {
+	res := make(map[string][]int64, len(prof.Sample))
+
+	for _, sample := range prof.Sample {
+		frames := make([]string, 0, len(sample.Location))
+
+		for i := len(sample.Location) - 1; i >= 0; i-- {
+			frames = append(frames, sample.Location[i].Line[0].Function.Name)
+		}
+
+		res[strings.Join(frames, ";")] = sample.Value
+	}
+
+	return res
+}
+
+func TestParseFolded(t *testing.T) {
+	t.Parallel()
+
+	t.Run("given collapsed stacks, then identical stacks are aggregated into a CPU profile", func(t *testing.T) {
+		t.Parallel()
+
+		folded := `main.main;main.handle;runtime.mallocgc 3
+main.main;main.handle 2
+
+main.main;main.handle;runtime.mallocgc 1
+`
+
+		prof, err := pprof.ParseFolded(strings.NewReader(folded), pprof.ConvertOptions{Period: time.Millisecond})
+		require.NoError(t, err)
+
+		require.Equal(t, "samples", prof.SampleType[0].Type)
+		require.Equal(t, "nanoseconds", prof.SampleType[1].Unit)
+		require.EqualValues(t, time.Millisecond, prof.Period)
+		require.Len(t, prof.Function, 3)
+		require.Equal(t, map[string][]int64{
+			"main.main;main.handle;runtime.mallocgc": {4, 4e6},
+			"main.main;main.handle":                  {2, 2e6},
+		}, stacks(prof))
+	})
+
+	testcases := []struct {
+		name   string
+		folded string
+	}{
+		{name: "when a line has no count, then an error is returned", folded: "main.main;main.handle"},
+		{name: "when a count is not a number, then an error is returned", folded: "main.main;main.handle many"},
+		{name: "when there are no stacks, then an error is returned", folded: "\n"},
+	}
+
+	for _, tt := range testcases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			prof, err := pprof.ParseFolded(strings.NewReader(tt.folded), pprof.ConvertOptions{})
+			require.ErrorIs(t, err, pprof.ErrUnparsableProfile)
+			require.Nil(t, prof)
+		})
+	}
+}
+
+func TestParsePerfScript(t *testing.T) {
+	t.Parallel()
+
+	t.Run("given the output of perf script, then its call chains are converted into a CPU profile", func(t *testing.T) {
+		t.Parallel()
+
+		perfScript := `# ========
+# captured on    : Tue Aug  1 10:00:00 2023
+# ========
+checkout 1234 [001] 12345.678901:     250000 cpu-clock:pppH: 
+	          4a1b2c main.handle+0x2c (/usr/bin/checkout)
+	          4a0f10 main.main+0x10 (/usr/bin/checkout)
+
+checkout 1234 [001] 12345.679151:     250000 cpu-clock:pppH: 
+	          4a1b2c main.handle+0x2c (/usr/bin/checkout)
+	          4a0f10 main.main+0x10 (/usr/bin/checkout)
+
+worker pool 1235 [002] 12345.679200:     1000 cycles: 
+	          4a2000 main.(*pool).run+0x1 (/usr/bin/checkout)
+	    7f0000000000 [unknown] ([unknown])
+`
+
+		prof, err := pprof.ParsePerfScript(strings.NewReader(perfScript), pprof.ConvertOptions{})
+		require.NoError(t, err)
+
+		require.Equal(t, map[string][]int64{
+			"main.main;main.handle": {2, 500000},
+			// The period of cycles is not a CPU time, so the default period is used instead.
+			"[unknown];main.(*pool).run": {1, pprof.DefaultConvertPeriod.Nanoseconds()},
+		}, stacks(prof))
+	})
+
+	t.Run("when a sample header has no timestamp, then an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		perfScript := "checkout 1234 cpu-clock\n\t4a1b2c main.main (/usr/bin/checkout)\n"
+
+		prof, err := pprof.ParsePerfScript(strings.NewReader(perfScript), pprof.ConvertOptions{})
+		require.ErrorIs(t, err, pprof.ErrUnparsableProfile)
+		require.Nil(t, prof)
+	})
+}
+
+func TestFormatParse(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		format      pprof.Format
+		data        []byte
+		expectedErr error
+	}{
+		{format: "", data: encode(t, newCPUProfile(t, stack{frames: []string{"main.main"}, value: 1}))},
+		{format: pprof.FormatFolded, data: []byte("main.main 1")},
+		{format: pprof.FormatPerfScript, data: []byte("checkout 1 0.1: 1 cpu-clock:\n\t1 main.main (/bin/checkout)\n")},
+		{format: pprof.FormatPprof, data: []byte("main.main 1"), expectedErr: pprof.ErrUnparsableProfile},
+		{format: "jfr", data: []byte("main.main 1"), expectedErr: pprof.ErrUnsupportedFormat},
+	}
+
+	for _, tt := range testcases {
+		tt := tt
+
+		t.Run(string(tt.format), func(t *testing.T) {
+			t.Parallel()
+
+			prof, err := tt.format.Parse(tt.data)
+			require.ErrorIs(t, err, tt.expectedErr)
+
+			if tt.expectedErr == nil {
+				require.NotNil(t, prof)
+			}
+		})
+	}
+}
+
+func TestConvertedProfilesCompilerPreprofile(t *testing.T) {
+	t.Parallel()
+
+	goBin, err := exec.LookPath("go")
+	if err != nil || testing.Short() {
+		t.Skip("needs the go toolchain to run go tool preprofile")
+	}
+
+	testcases := []struct {
+		format pprof.Format
+		data   string
+	}{
+		{format: pprof.FormatFolded, data: "main.main;main.handle 3\nmain.main;main.handle;runtime.mallocgc 1\n"},
+		{format: pprof.FormatPerfScript, data: "checkout 1 0.1: 1 cpu-clock:\n\t2 main.handle (/bin/checkout)\n\t1 main.main (/bin/checkout)\n"},
+		{format: pprof.FormatPreprofile, data: "GO PREPROFILE V1\nmain.main\nmain.handle\n4 3\n"},
+	}
+
+	for _, tt := range testcases {
+		tt := tt
+
+		t.Run(string(tt.format), func(t *testing.T) {
+			t.Parallel()
+
+			prof, err := tt.format.Parse([]byte(tt.data))
+			require.NoError(t, err)
+
+			// The merged output is what ends up in the PGO file.
+			var merged bytes.Buffer
+
+			require.NoError(t, pprof.MergeProfiles(&merged, nil, []*profile.Profile{prof}, pprof.MergeOptions{}))
+
+			dir := t.TempDir()
+			input, output := filepath.Join(dir, "default.pgo"), filepath.Join(dir, "default.preprofile")
+
+			require.NoError(t, os.WriteFile(input, merged.Bytes(), 0o600))
+
+			out, err := exec.Command(goBin, "tool", "preprofile", "-i", input, "-o", output).CombinedOutput()
+			require.NoError(t, err, string(out))
+
+			preprofile, err := os.ReadFile(output)
+			require.NoError(t, err)
+			require.Contains(t, string(preprofile), "main.main\nmain.handle\n")
+		})
+	}
+}
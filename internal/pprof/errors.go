@@ -0,0 +1,82 @@
+package pprof
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/macabu/cpgo/internal/httpclient"
+	"github.com/macabu/cpgo/internal/retry"
+)
+
+var (
+	ErrBodyTooLarge          = httpclient.ErrBodyTooLarge
+	ErrUnexpectedStatusCode  = errors.New("unexpected status code")
+	ErrUnexpectedContentType = errors.New("unexpected content type")
+	ErrEmptyBody             = errors.New("empty response body")
+	ErrUnparsableProfile     = errors.New("could not parse profile")
+	ErrNotCPUProfile         = errors.New("profile is not a CPU profile")
+	ErrNoMatchingFiles       = errors.New("no files match the pattern")
+	ErrUnsupportedFormat     = errors.New("unsupported profile format")
+	ErrInvalidThreshold      = errors.New("threshold must be between 0 and 1")
+	ErrMaxSizeTooSmall       = errors.New("not even the hottest sample fits into the maximum size")
+	ErrCallGraphChanged      = errors.New("call graph changed while minimizing the profile")
+	ErrInvalidLabelWeight    = errors.New("label weight must be positive")
+)
+
+// FetchError describes why a profile could not be fetched, wrapping one of the sentinel errors above.
+type FetchError struct {
+	URL         string
+	StatusCode  int
+	ContentType string
+	// Snippet is the beginning of the response body, to tell what the server responded with instead of a profile.
+	Snippet string
+	// Kind is one of the sentinel errors above.
+	Kind error
+	// Cause is the underlying error, if any.
+	Cause error
+
+	retryAfter time.Duration
+}
+
+func (e *FetchError) Error() string {
+	msg := fmt.Sprintf("%v: %v (status code %v, content type %q", e.URL, e.Kind, e.StatusCode, e.ContentType)
+
+	if e.Snippet != "" {
+		msg += fmt.Sprintf(", body %q", e.Snippet)
+	}
+
+	msg += ")"
+
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+
+	return msg
+}
+
+func (e *FetchError) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Kind}
+	}
+
+	return []error{e.Kind, e.Cause}
+}
+
+// RetryAfter is the delay requested by the server through the Retry-After header, zero if none.
+func (e *FetchError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// Permanent reports whether fetching again is pointless until something changes, e.g. the config or the backend.
+// Server errors, throttling, timeouts and empty bodies are transient, everything else is permanent.
+func (e *FetchError) Permanent() bool {
+	switch {
+	case errors.Is(e.Kind, ErrUnexpectedStatusCode):
+		return !retry.IsTransientStatus(e.StatusCode)
+	case errors.Is(e.Kind, ErrEmptyBody):
+		return false
+	default:
+		return true
+	}
+}
@@ -2,27 +2,81 @@ package pprof
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/pprof/profile"
+
+	"github.com/macabu/cpgo/internal/discovery"
+	"github.com/macabu/cpgo/internal/httpclient"
+	"github.com/macabu/cpgo/internal/retry"
+)
+
+const (
+	// defaultConcurrency bounds how many profiles are fetched at the same time when no concurrency is given.
+	defaultConcurrency = 4
+	// DefaultMaxBodySize of a profile, see httpclient.DefaultMaxBodySize.
+	DefaultMaxBodySize = httpclient.DefaultMaxBodySize
 )
 
+type FetcherOptions struct {
+	// MaxBodySize in bytes of a profile, defaults to DefaultMaxBodySize.
+	MaxBodySize int64
+	// Retry policy for fetching a profile, transient errors are not retried by default.
+	Retry retry.Policy
+}
+
 type Fetcher struct {
 	client *http.Client
+	opts   FetcherOptions
 }
 
 func NewFetcher(client *http.Client) *Fetcher {
+	return NewFetcherWithOptions(client, FetcherOptions{})
+}
+
+func NewFetcherWithOptions(client *http.Client, opts FetcherOptions) *Fetcher {
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
+
 	return &Fetcher{
 		client: client,
+		opts:   opts,
 	}
 }
 
-// FromURL fetches a profile from the designated `url` and parses it.
+// FromURL fetches a profile from the designated `url` and parses it. Errors about the response are a *FetchError.
+// Transient errors are retried according to the FetcherOptions.Retry policy.
 func (f Fetcher) FromURL(ctx context.Context, url string) (*profile.Profile, error) {
+	var prof *profile.Profile
+
+	err := retry.Do(ctx, f.opts.Retry, func(ctx context.Context) error {
+		var err error
+
+		prof, err = f.fetch(ctx, url)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return prof, nil
+}
+
+// fetch makes a single attempt at fetching and parsing the profile.
+func (f Fetcher) fetch(ctx context.Context, url string) (*profile.Profile, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("http.NewRequestWithContext: %w", err)
+		return nil, retry.Permanent(fmt.Errorf("http.NewRequestWithContext: %w", err))
 	}
 
 	resp, err := f.client.Do(req)
@@ -32,10 +86,167 @@ func (f Fetcher) FromURL(ctx context.Context, url string) (*profile.Profile, err
 
 	defer resp.Body.Close()
 
-	prof, err := profile.Parse(resp.Body)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.opts.MaxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll: %w", err)
+	}
+
+	fetchErr := &FetchError{
+		URL:         url,
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Snippet:     httpclient.Snippet(body),
+		retryAfter:  parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	if err := validateResponse(resp, body, f.opts.MaxBodySize); err != nil {
+		fetchErr.Kind = err
+
+		return nil, fetchErr
+	}
+
+	prof, err := parseData(body)
 	if err != nil {
-		return nil, fmt.Errorf("profile.Parse: %w", err)
+		fetchErr.Kind = ErrUnparsableProfile
+		fetchErr.Cause = err
+
+		return nil, fetchErr
+	}
+
+	if !isCPUProfile(prof) {
+		fetchErr.Kind = ErrNotCPUProfile
+		fetchErr.Snippet = fmt.Sprintf("sample types %v", prof.SampleType)
+
+		return nil, fetchErr
 	}
 
 	return prof, nil
 }
+
+// FromTargets fetches the profiles of all `targets` concurrently, with at most `concurrency` requests in flight.
+// The labels of each target are attached to the samples of its profile, unless a sample already has that label.
+// The profiles that could be fetched are returned even if others failed, alongside the joined errors.
+func (f Fetcher) FromTargets(ctx context.Context, targets []discovery.Target, concurrency int) ([]*profile.Profile, error) {
+	if concurrency < 1 {
+		concurrency = defaultConcurrency
+	}
+
+	var (
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, concurrency)
+		errs = make([]error, len(targets))
+		res  = make([]*profile.Profile, len(targets))
+	)
+
+	for i, target := range targets {
+		wg.Add(1)
+
+		sem <- struct{}{}
+
+		go func(i int, target discovery.Target) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			prof, err := f.FromURL(ctx, target.URL)
+			if err != nil {
+				errs[i] = fmt.Errorf("%v: %w", target.URL, err)
+
+				return
+			}
+
+			attachLabels(prof, target.Labels)
+
+			res[i] = prof
+		}(i, target)
+	}
+
+	wg.Wait()
+
+	profiles := make([]*profile.Profile, 0, len(targets))
+
+	for _, prof := range res {
+		if prof != nil {
+			profiles = append(profiles, prof)
+		}
+	}
+
+	return profiles, errors.Join(errs...)
+}
+
+// attachLabels to every sample of the profile, keeping the values the samples already have.
+func attachLabels(prof *profile.Profile, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+
+	for _, sample := range prof.Sample {
+		if sample.Label == nil {
+			sample.Label = make(map[string][]string, len(labels))
+		}
+
+		for k, v := range labels {
+			if _, ok := sample.Label[k]; !ok {
+				sample.Label[k] = []string{v}
+			}
+		}
+	}
+}
+
+// validateResponse checks the status code, content type and size of the response. Returns one of the sentinel errors.
+func validateResponse(resp *http.Response, body []byte, maxBodySize int64) error {
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return ErrUnexpectedStatusCode
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+
+		// Preprocessed profiles are text, e.g. when downloading the existing PGO file.
+		textual := err != nil || strings.HasPrefix(mediaType, "text/") || textualMediaTypes[mediaType]
+		if textual && !isPreprofile(body) {
+			return ErrUnexpectedContentType
+		}
+	}
+
+	if len(body) == 0 {
+		return ErrEmptyBody
+	}
+
+	if int64(len(body)) > maxBodySize {
+		return ErrBodyTooLarge
+	}
+
+	return nil
+}
+
+// textualMediaTypes are returned by error pages and APIs, but never by pprof handlers.
+var textualMediaTypes = map[string]bool{
+	"application/json":         true,
+	"application/xml":          true,
+	"application/xhtml+xml":    true,
+	"application/problem+json": true,
+}
+
+// isCPUProfile checks for the sample types the Go compiler looks for when using a profile for PGO.
+func isCPUProfile(prof *profile.Profile) bool {
+	return pgoSampleIndex(prof) >= 0
+}
+
+// parseRetryAfter supports both the delay in seconds and the HTTP date formats. Returns zero if absent or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+
+	return 0
+}
@@ -6,12 +6,17 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/pprof/profile"
 	"github.com/stretchr/testify/require"
 
+	"github.com/macabu/cpgo/internal/discovery"
 	"github.com/macabu/cpgo/internal/pprof"
+	"github.com/macabu/cpgo/internal/retry"
 )
 
 type mockRoundTripper func(r *http.Request) (*http.Response, error)
@@ -60,6 +65,24 @@ func TestFetcher(t *testing.T) {
 		require.NoError(t, actualProfile.CheckValid())
 	})
 
+	t.Run("given a preprocessed profile served as text, then it parses it", func(t *testing.T) {
+		t.Parallel()
+
+		client := &http.Client{
+			Transport: mockRoundTripper(func(r *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}},
+					Body:       io.NopCloser(strings.NewReader("GO PREPROFILE V1\nmain.main\nmain.work\n9 7\n")),
+				}, nil
+			}),
+		}
+
+		actualProfile, err := pprof.NewFetcher(client).FromURL(context.Background(), "does-not-matter")
+		require.NoError(t, err)
+		require.Len(t, actualProfile.Sample, 1)
+	})
+
 	t.Run("when creating the request fails due to an invalid url, then an error is returned", func(t *testing.T) {
 		t.Parallel()
 
@@ -90,6 +113,27 @@ func TestFetcher(t *testing.T) {
 		require.Nil(t, actualProfile)
 	})
 
+	t.Run("when the body is larger than the maximum size, then an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+
+		client := &http.Client{
+			Transport: mockRoundTripper(func(r *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewReader(make([]byte, 1024))),
+				}, nil
+			}),
+		}
+
+		fetcher := pprof.NewFetcherWithOptions(client, pprof.FetcherOptions{MaxBodySize: 512})
+
+		actualProfile, err := fetcher.FromURL(ctx, "does-not-matter")
+		require.ErrorIs(t, err, pprof.ErrBodyTooLarge)
+		require.Nil(t, actualProfile)
+	})
+
 	t.Run("given a invalid profile, then it returns an error", func(t *testing.T) {
 		t.Parallel()
 
@@ -109,3 +153,311 @@ func TestFetcher(t *testing.T) {
 		require.Nil(t, actualProfile)
 	})
 }
+
+func TestFetcherFromTargets(t *testing.T) {
+	t.Parallel()
+
+	profileValid := &profile.Profile{
+		TimeNanos:     10000,
+		PeriodType:    &profile.ValueType{Type: "cpu", Unit: "milliseconds"},
+		Period:        1,
+		DurationNanos: 10e9,
+		SampleType: []*profile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "milliseconds"},
+		},
+		Sample: []*profile.Sample{},
+	}
+
+	var w bytes.Buffer
+
+	err := profileValid.WriteUncompressed(&w)
+	require.NoError(t, err)
+
+	t.Run("given many urls, then it fetches all of them without exceeding the concurrency", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+
+		var inFlight, maxInFlight atomic.Int32
+
+		client := &http.Client{
+			Transport: mockRoundTripper(func(r *http.Request) (*http.Response, error) {
+				current := inFlight.Add(1)
+				defer inFlight.Add(-1)
+
+				for {
+					seen := maxInFlight.Load()
+					if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+						break
+					}
+				}
+
+				time.Sleep(10 * time.Millisecond)
+
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewReader(w.Bytes())),
+				}, nil
+			}),
+		}
+
+		targets := discovery.StaticTargets([]string{"pod-0", "pod-1", "pod-2", "pod-3", "pod-4", "pod-5"})
+
+		actualProfiles, err := pprof.NewFetcher(client).FromTargets(ctx, targets, 2)
+		require.NoError(t, err)
+		require.Len(t, actualProfiles, len(targets))
+		require.LessOrEqual(t, maxInFlight.Load(), int32(2))
+	})
+
+	t.Run("when some of the urls fail, then the other profiles are returned alongside the error", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		mockErr := fmt.Errorf("mock error")
+
+		client := &http.Client{
+			Transport: mockRoundTripper(func(r *http.Request) (*http.Response, error) {
+				if r.URL.Path == "pod-1" {
+					return nil, mockErr
+				}
+
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewReader(w.Bytes())),
+				}, nil
+			}),
+		}
+
+		targets := discovery.StaticTargets([]string{"pod-0", "pod-1", "pod-2"})
+
+		actualProfiles, err := pprof.NewFetcher(client).FromTargets(ctx, targets, 0)
+		require.ErrorIs(t, err, mockErr)
+		require.Len(t, actualProfiles, 2)
+	})
+
+	t.Run("given a target with labels, then they are attached to the samples without overriding existing ones", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+
+		fn := &profile.Function{ID: 1, Name: "main.main"}
+		loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn, Line: 10}}}
+
+		profileWithSamples := &profile.Profile{
+			PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+			Period:     1,
+			SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+			Sample: []*profile.Sample{
+				{Location: []*profile.Location{loc}, Value: []int64{10}},
+				{Location: []*profile.Location{loc}, Value: []int64{20}, Label: map[string][]string{"env": {"canary"}}},
+			},
+			Location: []*profile.Location{loc},
+			Function: []*profile.Function{fn},
+		}
+
+		var b bytes.Buffer
+
+		require.NoError(t, profileWithSamples.WriteUncompressed(&b))
+
+		client := &http.Client{
+			Transport: mockRoundTripper(func(r *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewReader(b.Bytes())),
+				}, nil
+			}),
+		}
+
+		targets := []discovery.Target{{URL: "pod-0", Labels: map[string]string{"env": "production", "team": "checkout"}}}
+
+		actualProfiles, err := pprof.NewFetcher(client).FromTargets(ctx, targets, 1)
+		require.NoError(t, err)
+		require.Len(t, actualProfiles, 1)
+		require.Len(t, actualProfiles[0].Sample, 2)
+		require.EqualValues(t, map[string][]string{"env": {"production"}, "team": {"checkout"}}, actualProfiles[0].Sample[0].Label)
+		require.EqualValues(t, map[string][]string{"env": {"canary"}, "team": {"checkout"}}, actualProfiles[0].Sample[1].Label)
+	})
+}
+
+func TestFetcherFromURLErrors(t *testing.T) {
+	t.Parallel()
+
+	heapProfile := &profile.Profile{
+		PeriodType: &profile.ValueType{Type: "space", Unit: "bytes"},
+		Period:     512 * 1024,
+		SampleType: []*profile.ValueType{
+			{Type: "alloc_objects", Unit: "count"},
+			{Type: "alloc_space", Unit: "bytes"},
+		},
+	}
+
+	var heap bytes.Buffer
+
+	require.NoError(t, heapProfile.WriteUncompressed(&heap))
+
+	testcases := []struct {
+		name              string
+		statusCode        int
+		contentType       string
+		body              []byte
+		expectedErr       error
+		expectedPermanent bool
+	}{
+		{
+			name:              "when the server responds with unauthorized, then it is a permanent status code error",
+			statusCode:        http.StatusUnauthorized,
+			body:              []byte(`Unauthorized`),
+			expectedErr:       pprof.ErrUnexpectedStatusCode,
+			expectedPermanent: true,
+		},
+		{
+			name:              "when the server is unavailable, then it is a transient status code error",
+			statusCode:        http.StatusServiceUnavailable,
+			contentType:       "text/html; charset=utf-8",
+			body:              []byte(`<html>Service Unavailable</html>`),
+			expectedErr:       pprof.ErrUnexpectedStatusCode,
+			expectedPermanent: false,
+		},
+		{
+			name:              "when the server responds with an HTML page, then it is a permanent content type error",
+			statusCode:        http.StatusOK,
+			contentType:       "text/html; charset=utf-8",
+			body:              []byte(`<html>Please log in</html>`),
+			expectedErr:       pprof.ErrUnexpectedContentType,
+			expectedPermanent: true,
+		},
+		{
+			name:              "when the server responds with an empty body, then it is a transient error",
+			statusCode:        http.StatusOK,
+			contentType:       "application/octet-stream",
+			body:              nil,
+			expectedErr:       pprof.ErrEmptyBody,
+			expectedPermanent: false,
+		},
+		{
+			name:              "when the body is not a profile, then it is a permanent unparsable error",
+			statusCode:        http.StatusOK,
+			contentType:       "application/octet-stream",
+			body:              []byte(`Something that is not a profile.`),
+			expectedErr:       pprof.ErrUnparsableProfile,
+			expectedPermanent: true,
+		},
+		{
+			name:              "when the profile is not a CPU profile, then it is a permanent error",
+			statusCode:        http.StatusOK,
+			contentType:       "application/octet-stream",
+			body:              heap.Bytes(),
+			expectedErr:       pprof.ErrNotCPUProfile,
+			expectedPermanent: true,
+		},
+	}
+
+	for _, tt := range testcases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			client := &http.Client{
+				Transport: mockRoundTripper(func(r *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: tt.statusCode,
+						Header:     http.Header{"Content-Type": []string{tt.contentType}},
+						Body:       io.NopCloser(bytes.NewReader(tt.body)),
+					}, nil
+				}),
+			}
+
+			actualProfile, err := pprof.NewFetcher(client).FromURL(ctx, "does-not-matter")
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.Nil(t, actualProfile)
+
+			var fetchErr *pprof.FetchError
+
+			require.ErrorAs(t, err, &fetchErr)
+			require.Equal(t, tt.statusCode, fetchErr.StatusCode)
+			require.Equal(t, tt.expectedPermanent, fetchErr.Permanent())
+			require.NotEmpty(t, fetchErr.Error())
+		})
+	}
+}
+
+func TestFetcherRetry(t *testing.T) {
+	t.Parallel()
+
+	profileValid := &profile.Profile{
+		PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     1,
+		SampleType: []*profile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+	}
+
+	var w bytes.Buffer
+
+	require.NoError(t, profileValid.WriteUncompressed(&w))
+
+	policy := retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+	t.Run("given a transient error, then it retries honouring the Retry-After header", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+
+		var attempts atomic.Int32
+
+		client := &http.Client{
+			Transport: mockRoundTripper(func(r *http.Request) (*http.Response, error) {
+				if attempts.Add(1) == 1 {
+					return &http.Response{
+						StatusCode: http.StatusServiceUnavailable,
+						Header:     http.Header{"Retry-After": []string{"0"}},
+						Body:       io.NopCloser(bytes.NewReader([]byte(`try again later`))),
+					}, nil
+				}
+
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewReader(w.Bytes())),
+				}, nil
+			}),
+		}
+
+		fetcher := pprof.NewFetcherWithOptions(client, pprof.FetcherOptions{Retry: policy})
+
+		actualProfile, err := fetcher.FromURL(ctx, "does-not-matter")
+		require.NoError(t, err)
+		require.NotNil(t, actualProfile)
+		require.Equal(t, int32(2), attempts.Load())
+	})
+
+	t.Run("given a permanent error, then it is not retried", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+
+		var attempts atomic.Int32
+
+		client := &http.Client{
+			Transport: mockRoundTripper(func(r *http.Request) (*http.Response, error) {
+				attempts.Add(1)
+
+				return &http.Response{
+					StatusCode: http.StatusForbidden,
+					Body:       io.NopCloser(bytes.NewReader([]byte(`forbidden`))),
+				}, nil
+			}),
+		}
+
+		fetcher := pprof.NewFetcherWithOptions(client, pprof.FetcherOptions{Retry: policy})
+
+		actualProfile, err := fetcher.FromURL(ctx, "does-not-matter")
+		require.ErrorIs(t, err, pprof.ErrUnexpectedStatusCode)
+		require.Nil(t, actualProfile)
+		require.Equal(t, int32(1), attempts.Load())
+	})
+}
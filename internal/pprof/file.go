@@ -0,0 +1,86 @@
+package pprof
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// FileScheme prefixes the URLs of profiles in the local filesystem, e.g. file:///var/profiles/*.pprof.
+const FileScheme = "file://"
+
+type FileSourceOptions struct {
+	// Format of the files, defaults to FormatPprof.
+	Format Format
+}
+
+// FileSource loads the profiles written to the local filesystem, matching a glob pattern.
+type FileSource struct {
+	pattern string
+	opts    FileSourceOptions
+}
+
+// NewFileSource from a `file://` URL, whose path may be a glob pattern.
+func NewFileSource(url string) *FileSource {
+	return NewFileSourceWithOptions(url, FileSourceOptions{})
+}
+
+func NewFileSourceWithOptions(url string, opts FileSourceOptions) *FileSource {
+	// Not parsed as a URL, since glob patterns are not valid paths, e.g. `?` would start a query.
+	pattern, _ := strings.CutPrefix(url, FileScheme)
+
+	return &FileSource{
+		pattern: pattern,
+		opts:    opts,
+	}
+}
+
+// IsFileURL reports whether the URL points to the local filesystem.
+func IsFileURL(url string) bool {
+	return strings.HasPrefix(url, FileScheme)
+}
+
+// Profiles loads every file matching the pattern, which are read anew on every run.
+func (s FileSource) Profiles(_ context.Context) ([]*profile.Profile, error) {
+	paths, err := filepath.Glob(s.pattern)
+	if err != nil {
+		return nil, fmt.Errorf("filepath.Glob: %w", err)
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%v: %w", s.pattern, ErrNoMatchingFiles)
+	}
+
+	var (
+		profiles = make([]*profile.Profile, 0, len(paths))
+		errs     []error
+	)
+
+	for _, path := range paths {
+		prof, err := loadFile(path, s.opts.Format)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", path, err))
+
+			continue
+		}
+
+		profiles = append(profiles, prof)
+	}
+
+	return profiles, errors.Join(errs...)
+}
+
+// loadFile parses the CPU profile at `path`.
+func loadFile(path string, format Format) (*profile.Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	return format.Parse(data)
+}
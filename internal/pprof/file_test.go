@@ -0,0 +1,83 @@
+package pprof_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/pprof"
+)
+
+func TestFileSource(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cpuProfile := newCPUProfile(t, stack{frames: []string{"main.main", "main.work"}, value: 10})
+
+	t.Run("given a glob pattern, then it loads all the matching profiles", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "job-1.pprof"), encode(t, cpuProfile), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "job-2.pprof"), encode(t, cpuProfile), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not matched"), 0o600))
+
+		url := pprof.FileScheme + filepath.Join(dir, "*.pprof")
+		require.True(t, pprof.IsFileURL(url))
+
+		profiles, err := pprof.NewFileSource(url).Profiles(ctx)
+		require.NoError(t, err)
+		require.Len(t, profiles, 2)
+	})
+
+	t.Run("when some files are not CPU profiles, then the others are returned alongside the error", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+
+		heapProfile := &profile.Profile{
+			PeriodType: &profile.ValueType{Type: "space", Unit: "bytes"},
+			SampleType: []*profile.ValueType{{Type: "alloc_space", Unit: "bytes"}},
+		}
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "cpu.pprof"), encode(t, cpuProfile), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "heap.pprof"), encode(t, heapProfile), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "garbage.pprof"), []byte("garbage"), 0o600))
+
+		profiles, err := pprof.NewFileSource(pprof.FileScheme + filepath.Join(dir, "*.pprof")).Profiles(ctx)
+		require.ErrorIs(t, err, pprof.ErrNotCPUProfile)
+		require.ErrorIs(t, err, pprof.ErrUnparsableProfile)
+		require.Len(t, profiles, 1)
+	})
+
+	t.Run("given a format, then the files are converted from it", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "perf.folded"), []byte("main.main;main.work 10\n"), 0o600))
+
+		source := pprof.NewFileSourceWithOptions(pprof.FileScheme+filepath.Join(dir, "*.folded"), pprof.FileSourceOptions{
+			Format: pprof.FormatFolded,
+		})
+
+		profiles, err := source.Profiles(ctx)
+		require.NoError(t, err)
+		require.Len(t, profiles, 1)
+		require.EqualValues(t, 10, profiles[0].Sample[0].Value[0])
+	})
+
+	t.Run("when no files match, then an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		profiles, err := pprof.NewFileSource(pprof.FileScheme + filepath.Join(t.TempDir(), "*.pprof")).Profiles(ctx)
+		require.ErrorIs(t, err, pprof.ErrNoMatchingFiles)
+		require.Nil(t, profiles)
+	})
+}
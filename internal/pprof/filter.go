@@ -0,0 +1,191 @@
+package pprof
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/google/pprof/profile"
+)
+
+// FilterOptions are regular expressions as the ones of the pprof CLI. They match the frames by their function name,
+// which starts with the package, e.g. `^runtime\.gcBgMarkWorker$` or `^github\.com/org/vendored/`, or by their file.
+// The samples can also be kept, dropped or weighed by their labels, e.g. `endpoint` or `tenant`.
+type FilterOptions struct {
+	// Focus keeps only the samples with a matching frame.
+	Focus string
+	// Ignore drops the samples with a matching frame.
+	Ignore string
+	// Hide removes the matching frames from the samples, which are kept.
+	Hide string
+	// PruneFrom removes the frames called by a matching frame, which becomes the leaf of the sample.
+	PruneFrom string
+	// KeepLabels keeps only the samples that have, for every key, one of the values, e.g. set with pprof.Labels.
+	KeepLabels map[string][]string
+	// DropLabels drops the samples that have, for any key, one of the values.
+	DropLabels map[string][]string
+	// LabelWeights multiply the values of the samples with matching labels, the first matching one applies.
+	LabelWeights []LabelWeight
+}
+
+// LabelWeight multiplies the values of the samples that have all the labels, e.g. to favour the traffic that matters.
+type LabelWeight struct {
+	Labels map[string]string
+	// Weight is positive, rounding the values and dropping the samples where they round to zero.
+	Weight float64
+}
+
+// Filter the samples of profiles, e.g. to exclude the code that should not influence the inlining decisions.
+type Filter struct {
+	focus        *regexp.Regexp
+	ignore       *regexp.Regexp
+	hide         *regexp.Regexp
+	pruneFrom    *regexp.Regexp
+	keepLabels   map[string][]string
+	dropLabels   map[string][]string
+	labelWeights []LabelWeight
+}
+
+// NewFilter compiles the regular expressions of the options, the empty ones are not applied, and checks the weights.
+func NewFilter(opts FilterOptions) (*Filter, error) {
+	f := Filter{
+		keepLabels:   opts.KeepLabels,
+		dropLabels:   opts.DropLabels,
+		labelWeights: opts.LabelWeights,
+	}
+
+	for _, labelWeight := range opts.LabelWeights {
+		if labelWeight.Weight <= 0 {
+			return nil, fmt.Errorf("%v: %w", labelWeight.Weight, ErrInvalidLabelWeight)
+		}
+	}
+
+	var err error
+
+	for _, option := range []struct {
+		name    string
+		expr    string
+		compile **regexp.Regexp
+	}{
+		{"focus", opts.Focus, &f.focus},
+		{"ignore", opts.Ignore, &f.ignore},
+		{"hide", opts.Hide, &f.hide},
+		{"prune_from", opts.PruneFrom, &f.pruneFrom},
+	} {
+		if option.expr == "" {
+			continue
+		}
+
+		*option.compile, err = regexp.Compile(option.expr)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", option.name, err)
+		}
+	}
+
+	return &f, nil
+}
+
+// Apply the filter to the profile, which is not modified. The samples left without frames are dropped, and so are the
+// locations and functions that are not used anymore.
+func (f *Filter) Apply(prof *profile.Profile) *profile.Profile {
+	if f.focus == nil && f.ignore == nil && f.hide == nil && f.pruneFrom == nil &&
+		len(f.keepLabels) == 0 && len(f.dropLabels) == 0 && len(f.labelWeights) == 0 {
+		return prof
+	}
+
+	prof = prof.Copy()
+
+	f.applyLabels(prof)
+
+	prof.FilterSamplesByName(f.focus, f.ignore, f.hide, nil)
+
+	if f.pruneFrom != nil {
+		prof.PruneFrom(f.pruneFrom)
+	}
+
+	samples := prof.Sample[:0]
+
+	for _, sample := range prof.Sample {
+		if len(sample.Location) > 0 {
+			samples = append(samples, sample)
+		}
+	}
+
+	prof.Sample = samples
+
+	return prof.Compact()
+}
+
+// applyLabels keeps, drops and weighs the samples by their labels.
+func (f *Filter) applyLabels(prof *profile.Profile) {
+	samples := prof.Sample[:0]
+
+	for _, sample := range prof.Sample {
+		if len(f.keepLabels) > 0 && !hasAllLabels(sample, f.keepLabels) {
+			continue
+		}
+
+		if hasAnyLabel(sample, f.dropLabels) {
+			continue
+		}
+
+		for _, labelWeight := range f.labelWeights {
+			if hasLabels(sample, labelWeight.Labels) {
+				factors := make([]float64, len(sample.Value))
+
+				for i := range factors {
+					factors[i] = labelWeight.Weight
+				}
+
+				if !scaleSample(sample, factors) {
+					sample = nil
+				}
+
+				break
+			}
+		}
+
+		if sample != nil {
+			samples = append(samples, sample)
+		}
+	}
+
+	prof.Sample = samples
+}
+
+// hasAllLabels reports whether the sample has, for every key, one of the values.
+func hasAllLabels(sample *profile.Sample, labels map[string][]string) bool {
+	for key, values := range labels {
+		if !slices.ContainsFunc(sample.Label[key], func(value string) bool {
+			return slices.Contains(values, value)
+		}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasAnyLabel reports whether the sample has, for any key, one of the values.
+func hasAnyLabel(sample *profile.Sample, labels map[string][]string) bool {
+	for key, values := range labels {
+		if slices.ContainsFunc(sample.Label[key], func(value string) bool {
+			return slices.Contains(values, value)
+		}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasLabels reports whether the sample has all the labels.
+func hasLabels(sample *profile.Sample, labels map[string]string) bool {
+	for key, value := range labels {
+		if !slices.Contains(sample.Label[key], value) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,178 @@
+package pprof_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/pprof"
+)
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	newProfile := func(t *testing.T) *profile.Profile {
+		t.Helper()
+
+		return newCPUProfile(t,
+			stack{
+				frames: []string{"main.main", "example.com/app.handle", "example.com/app.parse"},
+				value:  10,
+				labels: map[string][]string{"endpoint": {"checkout"}, "tenant": {"acme"}},
+			},
+			stack{
+				frames: []string{"main.main", "example.com/app.handle", "runtime.mallocgc", "runtime.memclr"},
+				value:  5,
+				labels: map[string][]string{"endpoint": {"batch"}, "tenant": {"internal"}},
+			},
+			stack{frames: []string{"runtime.gcBgMarkWorker", "runtime.scanobject"}, value: 20},
+			stack{frames: []string{"testing.tRunner", "example.com/app.TestHandle"}, value: 3},
+		)
+	}
+
+	// stacks returns the samples as `root;...;leaf value`.
+	stacks := func(prof *profile.Profile) []string {
+		var stacks []string
+
+		for _, sample := range prof.Sample {
+			var frames []string
+
+			for i := len(sample.Location) - 1; i >= 0; i-- {
+				frames = append(frames, sample.Location[i].Line[0].Function.Name)
+			}
+
+			stacks = append(stacks, fmt.Sprintf("%v %v", strings.Join(frames, ";"), sample.Value[0]))
+		}
+
+		return stacks
+	}
+
+	testcases := []struct {
+		name     string
+		opts     pprof.FilterOptions
+		expected []string
+	}{
+		{
+			name: "given no options, then the profile is kept as is",
+			opts: pprof.FilterOptions{},
+			expected: []string{
+				"main.main;example.com/app.handle;example.com/app.parse 10",
+				"main.main;example.com/app.handle;runtime.mallocgc;runtime.memclr 5",
+				"runtime.gcBgMarkWorker;runtime.scanobject 20",
+				"testing.tRunner;example.com/app.TestHandle 3",
+			},
+		},
+		{
+			name: "given focus, then only the samples with a matching frame are kept",
+			opts: pprof.FilterOptions{Focus: `^example\.com/app\.handle$`},
+			expected: []string{
+				"main.main;example.com/app.handle;example.com/app.parse 10",
+				"main.main;example.com/app.handle;runtime.mallocgc;runtime.memclr 5",
+			},
+		},
+		{
+			name: "given ignore, then the samples with a matching frame are dropped",
+			opts: pprof.FilterOptions{Ignore: `^runtime\.gcBgMarkWorker$|^testing\.`},
+			expected: []string{
+				"main.main;example.com/app.handle;example.com/app.parse 10",
+				"main.main;example.com/app.handle;runtime.mallocgc;runtime.memclr 5",
+			},
+		},
+		{
+			name: "given hide, then the matching frames are removed",
+			opts: pprof.FilterOptions{Hide: `^runtime\.`},
+			expected: []string{
+				"main.main;example.com/app.handle;example.com/app.parse 10",
+				"main.main;example.com/app.handle 5",
+				"testing.tRunner;example.com/app.TestHandle 3",
+			},
+		},
+		{
+			name: "given prune_from, then the frames called by a matching frame are removed",
+			opts: pprof.FilterOptions{PruneFrom: `^runtime\.mallocgc$`},
+			expected: []string{
+				"main.main;example.com/app.handle;example.com/app.parse 10",
+				"main.main;example.com/app.handle;runtime.mallocgc 5",
+				"runtime.gcBgMarkWorker;runtime.scanobject 20",
+				"testing.tRunner;example.com/app.TestHandle 3",
+			},
+		},
+		{
+			name: "given keep labels, then only the samples with one of the values of every key are kept",
+			opts: pprof.FilterOptions{KeepLabels: map[string][]string{"endpoint": {"checkout", "batch"}, "tenant": {"acme"}}},
+			expected: []string{
+				"main.main;example.com/app.handle;example.com/app.parse 10",
+			},
+		},
+		{
+			name: "given drop labels, then the samples with one of the values of any key are dropped",
+			opts: pprof.FilterOptions{DropLabels: map[string][]string{"tenant": {"internal"}, "endpoint": {"health"}}},
+			expected: []string{
+				"main.main;example.com/app.handle;example.com/app.parse 10",
+				"runtime.gcBgMarkWorker;runtime.scanobject 20",
+				"testing.tRunner;example.com/app.TestHandle 3",
+			},
+		},
+		{
+			name: "given label weights, then the samples with all the labels are weighed by the first matching one",
+			opts: pprof.FilterOptions{LabelWeights: []pprof.LabelWeight{
+				{Labels: map[string]string{"endpoint": "checkout"}, Weight: 3},
+				{Labels: map[string]string{"endpoint": "checkout", "tenant": "acme"}, Weight: 5},
+				{Labels: map[string]string{"endpoint": "batch"}, Weight: 0.05},
+			}},
+			expected: []string{
+				"main.main;example.com/app.handle;example.com/app.parse 30",
+				"runtime.gcBgMarkWorker;runtime.scanobject 20",
+				"testing.tRunner;example.com/app.TestHandle 3",
+			},
+		},
+	}
+
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			prof := newProfile(t)
+
+			filter, err := pprof.NewFilter(tt.opts)
+			require.NoError(t, err)
+
+			filtered := filter.Apply(prof)
+			require.ElementsMatch(t, tt.expected, stacks(filtered))
+			require.NoError(t, filtered.CheckValid())
+
+			// The caller's profile is left untouched.
+			require.Len(t, prof.Sample, 4)
+		})
+	}
+
+	t.Run("given hide, then the unused functions are dropped", func(t *testing.T) {
+		t.Parallel()
+
+		filter, err := pprof.NewFilter(pprof.FilterOptions{Hide: `^runtime\.`})
+		require.NoError(t, err)
+
+		for _, fn := range filter.Apply(newProfile(t)).Function {
+			require.NotContains(t, fn.Name, "runtime.")
+		}
+	})
+
+	t.Run("when a regular expression is invalid, then an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := pprof.NewFilter(pprof.FilterOptions{Ignore: `(`})
+		require.ErrorContains(t, err, "ignore")
+	})
+
+	t.Run("when a label weight is not positive, then an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := pprof.NewFilter(pprof.FilterOptions{LabelWeights: []pprof.LabelWeight{
+			{Labels: map[string]string{"endpoint": "batch"}, Weight: 0},
+		}})
+		require.ErrorIs(t, err, pprof.ErrInvalidLabelWeight)
+	})
+}
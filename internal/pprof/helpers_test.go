@@ -0,0 +1,80 @@
+package pprof_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+// stack is a sample of a CPU profile, with its frames listed from the root to the leaf.
+type stack struct {
+	frames []string
+	value  int64
+	labels map[string][]string
+}
+
+// newCPUProfile builds a CPU profile as produced by the Go runtime, with one sample per stack.
+// Each frame is a function in its own location, called from line 10 of its caller, which starts at line 1.
+func newCPUProfile(t *testing.T, stacks ...stack) *profile.Profile {
+	t.Helper()
+
+	prof := &profile.Profile{
+		TimeNanos:     10000,
+		PeriodType:    &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:        10000000,
+		DurationNanos: 10e9,
+		SampleType: []*profile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+	}
+
+	functions := map[string]*profile.Function{}
+	locations := map[string]*profile.Location{}
+
+	for _, st := range stacks {
+		sample := &profile.Sample{
+			Value: []int64{st.value, st.value * prof.Period},
+			Label: st.labels,
+		}
+
+		for i := len(st.frames) - 1; i >= 0; i-- {
+			name := st.frames[i]
+
+			fn, ok := functions[name]
+			if !ok {
+				fn = &profile.Function{ID: uint64(len(functions) + 1), Name: name, SystemName: name, Filename: "main.go", StartLine: 1}
+				functions[name] = fn
+				prof.Function = append(prof.Function, fn)
+			}
+
+			loc, ok := locations[name]
+			if !ok {
+				loc = &profile.Location{ID: uint64(len(locations) + 1), Line: []profile.Line{{Function: fn, Line: 10}}}
+				locations[name] = loc
+				prof.Location = append(prof.Location, loc)
+			}
+
+			sample.Location = append(sample.Location, loc)
+		}
+
+		prof.Sample = append(prof.Sample, sample)
+	}
+
+	require.NoError(t, prof.CheckValid())
+
+	return prof
+}
+
+// encode the profile as the pprof handlers do.
+func encode(t *testing.T, prof *profile.Profile) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	require.NoError(t, prof.Write(&b))
+
+	return b.Bytes()
+}
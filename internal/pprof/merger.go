@@ -1,22 +1,269 @@
 package pprof
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"time"
 
 	"github.com/google/pprof/profile"
 )
 
-// MergeProfiles writes the merged uncompressed profile into `w`.
-func MergeProfiles(w io.Writer, profiles []*profile.Profile) error {
-	mergedProfile, err := profile.Merge(profiles)
+var (
+	ErrNoProfiles           = errors.New("no profiles to merge")
+	ErrUnsupportedMergeMode = errors.New("unsupported merge mode")
+	ErrInvalidWeight        = errors.New("weight must be above 0 and up to 1")
+	ErrInvalidDecay         = errors.New("decay must be between 0 and 1, exclusive")
+	ErrIncompatibleProfiles = errors.New("profiles have no sample type usable for PGO in common")
+)
+
+// MergeMode decides how the new profiles are merged with the existing one.
+type MergeMode string
+
+const (
+	// MergeAccumulate gives the existing profile, which holds all past merges, the same weight as the new profiles.
+	MergeAccumulate MergeMode = "accumulate"
+	// MergeReplace ignores the existing profile.
+	MergeReplace MergeMode = "replace"
+	// MergeWeighted gives the new profiles a fixed share of the merged profile, see MergeOptions.Weight.
+	MergeWeighted MergeMode = "weighted"
+	// MergeDecay scales down the existing profile before merging, see MergeOptions.Decay.
+	MergeDecay MergeMode = "decay"
+)
+
+type MergeOptions struct {
+	// Mode defaults to MergeAccumulate.
+	Mode MergeMode
+	// Weight of the new profiles in MergeWeighted mode, above 0 and up to 1. The existing profile has the rest.
+	Weight float64
+	// Decay multiplies the values of the existing profile in MergeDecay mode, so that past traffic ages out.
+	// Between 0 and 1, exclusive, see HalfLifeDecay.
+	Decay float64
+	// OnAdjustment is called for every adjustment made to the profiles so that they can be merged, if set.
+	OnAdjustment func(Adjustment)
+	// Prune the merged profile, if set, so that it does not keep growing.
+	Prune PruneOptions
+	// Minimal strips what the Go compiler does not use from the merged profile, see Minimize.
+	Minimal bool
+	// Format of what is written, either FormatPprof (default) or FormatPreprofile.
+	Format Format
+	// Companion, if set, also receives the merged profile in FormatPreprofile, e.g. for a file next to the pprof one.
+	Companion io.Writer
+}
+
+// HalfLifeDecay returns the decay that halves the weight of a profile every `halfLife`, when merging every `interval`.
+func HalfLifeDecay(halfLife, interval time.Duration) float64 {
+	if halfLife <= 0 || interval <= 0 {
+		return 1
+	}
+
+	return math.Pow(0.5, float64(interval)/float64(halfLife))
+}
+
+// MergeProfiles merges the `existing` profile, if any, with the new `profiles` according to the mode of the options,
+// and writes the uncompressed result into `w`.
+func MergeProfiles(w io.Writer, existing *profile.Profile, profiles []*profile.Profile, opts MergeOptions) error {
+	if len(profiles) == 0 && (existing == nil || opts.Mode == MergeReplace) {
+		return ErrNoProfiles
+	}
+
+	switch opts.Mode {
+	case "", MergeAccumulate, MergeWeighted, MergeDecay:
+	case MergeReplace:
+		existing = nil
+	default:
+		return fmt.Errorf("%v: %w", opts.Mode, ErrUnsupportedMergeMode)
+	}
+
+	existing, profiles, err := normalize(existing, profiles, opts.OnAdjustment)
+	if err != nil {
+		return fmt.Errorf("normalize: %w", err)
+	}
+
+	var merged *profile.Profile
+
+	switch opts.Mode {
+	case MergeDecay:
+		merged, err = mergeDecayed(existing, profiles, opts.Decay)
+	case MergeWeighted:
+		merged, err = mergeWeighted(existing, profiles, opts.Weight)
+	default:
+		merged, err = merge(existing, profiles...)
+	}
+
 	if err != nil {
-		return fmt.Errorf("profile.Merge: %w", err)
+		return err
 	}
 
-	if err := mergedProfile.WriteUncompressed(w); err != nil {
-		return fmt.Errorf("mergedProfile.WriteUncompressed: %w", err)
+	// Before pruning, so that the maximum size applies to what is written.
+	if opts.Minimal {
+		merged, err = Minimize(merged)
+		if err != nil {
+			return fmt.Errorf("Minimize: %w", err)
+		}
+	}
+
+	if opts.Prune != (PruneOptions{}) {
+		merged, err = Prune(merged, opts.Prune)
+		if err != nil {
+			return fmt.Errorf("Prune: %w", err)
+		}
+	}
+
+	canonicalize(merged)
+
+	switch opts.Format {
+	case "", FormatPprof:
+		if err := merged.WriteUncompressed(w); err != nil {
+			return fmt.Errorf("mergedProfile.WriteUncompressed: %w", err)
+		}
+	case FormatPreprofile:
+		if err := WritePreprofile(w, merged); err != nil {
+			return fmt.Errorf("WritePreprofile: %w", err)
+		}
+	default:
+		return fmt.Errorf("%v: %w", opts.Format, ErrUnsupportedFormat)
+	}
+
+	if opts.Companion != nil {
+		if err := WritePreprofile(opts.Companion, merged); err != nil {
+			return fmt.Errorf("WritePreprofile: %w", err)
+		}
 	}
 
 	return nil
 }
+
+// merge the existing profile, if any, with the others.
+func merge(existing *profile.Profile, profiles ...*profile.Profile) (*profile.Profile, error) {
+	if existing != nil {
+		profiles = append([]*profile.Profile{existing}, profiles...)
+	}
+
+	merged, err := profile.Merge(profiles)
+	if err != nil {
+		return nil, fmt.Errorf("profile.Merge: %w", err)
+	}
+
+	return merged, nil
+}
+
+// mergeDecayed scales down the existing profile by `factor` before merging.
+func mergeDecayed(existing *profile.Profile, profiles []*profile.Profile, factor float64) (*profile.Profile, error) {
+	// Otherwise the existing profile would either be accumulated as is, or wiped out.
+	if factor <= 0 || factor >= 1 {
+		return nil, fmt.Errorf("%v: %w", factor, ErrInvalidDecay)
+	}
+
+	if existing != nil {
+		// The existing profile is not modified, as the caller may still use it.
+		existing = existing.Copy()
+
+		scale(existing, factor)
+	}
+
+	return merge(existing, profiles...)
+}
+
+// mergeWeighted scales the existing and new profiles, so the new ones make up `weight` of the total of the result.
+func mergeWeighted(existing *profile.Profile, profiles []*profile.Profile, weight float64) (*profile.Profile, error) {
+	if weight <= 0 || weight > 1 {
+		return nil, fmt.Errorf("%v: %w", weight, ErrInvalidWeight)
+	}
+
+	newProfile, err := merge(nil, profiles...)
+	if err != nil {
+		return nil, err
+	}
+
+	existingTotal, newTotal := pgoTotal(existing), pgoTotal(newProfile)
+
+	// Nothing to weigh against, e.g. on the first run.
+	if existingTotal == 0 || newTotal == 0 {
+		return merge(existing, newProfile)
+	}
+
+	// The total is preserved, so that the values keep the same order of magnitude across runs.
+	total := float64(existingTotal + newTotal)
+
+	existing = existing.Copy()
+
+	scale(existing, (1-weight)*total/float64(existingTotal))
+	scale(newProfile, weight*total/float64(newTotal))
+
+	return merge(existing, newProfile)
+}
+
+// pgoTotal returns the sum of the sample type the Go compiler uses for PGO, zero for a nil profile.
+func pgoTotal(prof *profile.Profile) int64 {
+	if prof == nil {
+		return 0
+	}
+
+	i := pgoSampleIndex(prof)
+	if i < 0 {
+		return 0
+	}
+
+	var total int64
+
+	for _, sample := range prof.Sample {
+		total += sample.Value[i]
+	}
+
+	return total
+}
+
+// pgoSampleIndex returns the index of the sample type the Go compiler uses for PGO, or -1 if there is none.
+func pgoSampleIndex(prof *profile.Profile) int {
+	for i, sampleType := range prof.SampleType {
+		if (sampleType.Type == "samples" && sampleType.Unit == "count") ||
+			(sampleType.Type == "cpu" && sampleType.Unit == "nanoseconds") {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// scale multiplies the values of the samples by `factor`, rounding them. The samples where any value rounds to zero
+// are dropped, e.g. a single sample with 10ms of CPU time, so functions that are not called anymore disappear.
+func scale(prof *profile.Profile, factor float64) {
+	factors := make([]float64, len(prof.SampleType))
+
+	for i := range factors {
+		factors[i] = factor
+	}
+
+	scaleSampleTypes(prof, factors)
+}
+
+// scaleSampleTypes multiplies the values of each sample type by its factor, see scale.
+func scaleSampleTypes(prof *profile.Profile, factors []float64) {
+	samples := prof.Sample[:0]
+
+	for _, sample := range prof.Sample {
+		if scaleSample(sample, factors) {
+			samples = append(samples, sample)
+		}
+	}
+
+	prof.Sample = samples
+}
+
+// scaleSample multiplies each value of the sample by its factor, rounding it. Reports whether the sample is kept, i.e.
+// none of its nonzero values rounded to zero.
+func scaleSample(sample *profile.Sample, factors []float64) bool {
+	keep := true
+
+	for i, v := range sample.Value {
+		sample.Value[i] = int64(math.Round(float64(v) * factors[i]))
+
+		if v != 0 && sample.Value[i] == 0 {
+			keep = false
+		}
+	}
+
+	return keep
+}
@@ -3,6 +3,7 @@ package pprof_test
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	"github.com/google/pprof/profile"
 	"github.com/stretchr/testify/require"
@@ -25,7 +26,130 @@ func TestMergeProfiles(t *testing.T) {
 
 	var w bytes.Buffer
 
-	err := pprof.MergeProfiles(&w, []*profile.Profile{profileValid, profileValid})
+	err := pprof.MergeProfiles(&w, nil, []*profile.Profile{profileValid, profileValid}, pprof.MergeOptions{})
 	require.NoError(t, err)
 	require.NotNil(t, w.Bytes())
+
+	t.Run("given a decay, then the existing profile is scaled down before merging", func(t *testing.T) {
+		existing := newCPUProfile(t,
+			stack{frames: []string{"main.main", "main.old"}, value: 100},
+			stack{frames: []string{"main.main", "main.deleted"}, value: 1},
+		)
+		newProfile := newCPUProfile(t, stack{frames: []string{"main.main", "main.old"}, value: 10})
+
+		var w bytes.Buffer
+
+		opts := pprof.MergeOptions{Mode: pprof.MergeDecay, Decay: 0.4}
+
+		require.NoError(t, pprof.MergeProfiles(&w, existing, []*profile.Profile{newProfile}, opts))
+
+		merged, err := profile.Parse(&w)
+		require.NoError(t, err)
+
+		// main.deleted rounds down to zero, so it is gone.
+		require.Len(t, merged.Sample, 1)
+		require.EqualValues(t, 40+10, merged.Sample[0].Value[0])
+
+		// The caller's existing profile is left untouched.
+		require.EqualValues(t, 100, existing.Sample[0].Value[0])
+	})
+
+	t.Run("given a merge mode, then the existing profile is weighed accordingly", func(t *testing.T) {
+		existing := newCPUProfile(t, stack{frames: []string{"main.main", "main.old"}, value: 300})
+		newProfile := newCPUProfile(t, stack{frames: []string{"main.main", "main.new"}, value: 100})
+
+		testcases := []struct {
+			opts     pprof.MergeOptions
+			expected map[string]int64
+		}{
+			{
+				opts:     pprof.MergeOptions{},
+				expected: map[string]int64{"main.old": 300, "main.new": 100},
+			},
+			{
+				opts:     pprof.MergeOptions{Mode: pprof.MergeAccumulate},
+				expected: map[string]int64{"main.old": 300, "main.new": 100},
+			},
+			{
+				opts:     pprof.MergeOptions{Mode: pprof.MergeReplace},
+				expected: map[string]int64{"main.new": 100},
+			},
+			{
+				opts:     pprof.MergeOptions{Mode: pprof.MergeDecay, Decay: 0.5},
+				expected: map[string]int64{"main.old": 150, "main.new": 100},
+			},
+			{
+				// The total of 400 is preserved, and split 50/50.
+				opts:     pprof.MergeOptions{Mode: pprof.MergeWeighted, Weight: 0.5},
+				expected: map[string]int64{"main.old": 200, "main.new": 200},
+			},
+		}
+
+		for _, tt := range testcases {
+			var w bytes.Buffer
+
+			require.NoError(t, pprof.MergeProfiles(&w, existing, []*profile.Profile{newProfile}, tt.opts))
+
+			merged, err := profile.Parse(&w)
+			require.NoError(t, err)
+
+			actual := make(map[string]int64, len(merged.Sample))
+
+			for _, sample := range merged.Sample {
+				actual[sample.Location[0].Line[0].Function.Name] = sample.Value[0]
+			}
+
+			require.Equal(t, tt.expected, actual, tt.opts.Mode)
+		}
+	})
+
+	t.Run("given the weighted mode without an existing profile, then the new profiles are kept as is", func(t *testing.T) {
+		newProfile := newCPUProfile(t, stack{frames: []string{"main.main", "main.new"}, value: 100})
+
+		var w bytes.Buffer
+
+		opts := pprof.MergeOptions{Mode: pprof.MergeWeighted, Weight: 0.2}
+
+		require.NoError(t, pprof.MergeProfiles(&w, nil, []*profile.Profile{newProfile}, opts))
+
+		merged, err := profile.Parse(&w)
+		require.NoError(t, err)
+		require.EqualValues(t, 100, merged.Sample[0].Value[0])
+	})
+
+	t.Run("when the options are invalid, then an error is returned", func(t *testing.T) {
+		newProfile := newCPUProfile(t, stack{frames: []string{"main.main"}, value: 1})
+
+		var w bytes.Buffer
+
+		newProfiles := []*profile.Profile{newProfile}
+
+		require.ErrorIs(t, pprof.MergeProfiles(&w, nil, nil, pprof.MergeOptions{}), pprof.ErrNoProfiles)
+		require.ErrorIs(t, pprof.MergeProfiles(&w, newProfile, nil, pprof.MergeOptions{Mode: pprof.MergeReplace}), pprof.ErrNoProfiles)
+
+		testcases := []struct {
+			opts        pprof.MergeOptions
+			expectedErr error
+		}{
+			{opts: pprof.MergeOptions{Mode: "average"}, expectedErr: pprof.ErrUnsupportedMergeMode},
+			{opts: pprof.MergeOptions{Mode: pprof.MergeWeighted}, expectedErr: pprof.ErrInvalidWeight},
+			{opts: pprof.MergeOptions{Mode: pprof.MergeWeighted, Weight: 2}, expectedErr: pprof.ErrInvalidWeight},
+			{opts: pprof.MergeOptions{Mode: pprof.MergeDecay}, expectedErr: pprof.ErrInvalidDecay},
+			{opts: pprof.MergeOptions{Mode: pprof.MergeDecay, Decay: 1}, expectedErr: pprof.ErrInvalidDecay},
+			{opts: pprof.MergeOptions{Mode: pprof.MergeDecay, Decay: 1.5}, expectedErr: pprof.ErrInvalidDecay},
+		}
+
+		for _, tt := range testcases {
+			require.ErrorIs(t, pprof.MergeProfiles(&w, nil, newProfiles, tt.opts), tt.expectedErr, tt.opts)
+		}
+	})
+}
+
+func TestHalfLifeDecay(t *testing.T) {
+	t.Parallel()
+
+	require.InDelta(t, 0.5, pprof.HalfLifeDecay(7*24*time.Hour, 7*24*time.Hour), 1e-9)
+	require.InDelta(t, 0.25, pprof.HalfLifeDecay(time.Hour, 2*time.Hour), 1e-9)
+	require.InDelta(t, 0.5, pprof.HalfLifeDecay(24*time.Hour, time.Hour)*pprof.HalfLifeDecay(24*time.Hour, 23*time.Hour), 1e-9)
+	require.InDelta(t, 1.0, pprof.HalfLifeDecay(0, time.Hour), 1e-9)
 }
@@ -0,0 +1,78 @@
+package pprof
+
+import (
+	"maps"
+
+	"github.com/google/pprof/profile"
+)
+
+// Minimize returns the profile stripped of what the Go compiler does not use for PGO: the labels, the sample types
+// other than the one of PGO, the mappings, the addresses and the comments. The compiler only needs the function
+// names and the lines of the calls, so the files and their diffs are smaller, and the builds faster.
+// The call graph of the result is checked to be the same, the profile is not modified.
+func Minimize(prof *profile.Profile) (*profile.Profile, error) {
+	index := pgoSampleIndex(prof)
+	if index < 0 {
+		return nil, ErrNotCPUProfile
+	}
+
+	minimal := prof.Copy()
+
+	minimal.SampleType = []*profile.ValueType{minimal.SampleType[index]}
+	minimal.DefaultSampleType = ""
+	minimal.Mapping = nil
+	minimal.Comments = nil
+	minimal.DropFrames = ""
+	minimal.KeepFrames = ""
+
+	samples := minimal.Sample[:0]
+
+	for _, sample := range minimal.Sample {
+		// The samples that do not count for PGO, e.g. with CPU time but no samples, would only take space.
+		if sample.Value[index] == 0 {
+			continue
+		}
+
+		sample.Value = []int64{sample.Value[index]}
+		sample.Label = nil
+		sample.NumLabel = nil
+		sample.NumUnit = nil
+
+		samples = append(samples, sample)
+	}
+
+	minimal.Sample = samples
+
+	for _, loc := range minimal.Location {
+		loc.Mapping = nil
+		loc.Address = 0
+		loc.IsFolded = false
+	}
+
+	// The samples that only differed by their labels are merged.
+	minimal = minimal.Compact()
+
+	if !maps.Equal(callGraph(prof, index), callGraph(minimal, 0)) {
+		return nil, ErrCallGraphChanged
+	}
+
+	return minimal, nil
+}
+
+// callGraph returns the weight of every call edge of the stacks of the profile.
+func callGraph(prof *profile.Profile, index int) map[edge]int64 {
+	weights := map[edge]int64{}
+
+	for _, sample := range prof.Sample {
+		for _, e := range sampleEdges(sample) {
+			weights[e] += sample.Value[index]
+		}
+	}
+
+	// The edges without weight are not part of the graph.
+	maps.DeleteFunc(weights, func(_ edge, weight int64) bool {
+		return weight == 0
+	})
+
+	return weights
+}
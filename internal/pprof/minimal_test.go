@@ -0,0 +1,99 @@
+package pprof_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/pprof"
+)
+
+func TestMinimize(t *testing.T) {
+	t.Parallel()
+
+	newProfile := func(t *testing.T) *profile.Profile {
+		t.Helper()
+
+		prof := newCPUProfile(t,
+			stack{frames: []string{"main.main", "main.work"}, value: 10, labels: map[string][]string{"handler": {"a"}}},
+			stack{frames: []string{"main.main", "main.work"}, value: 5, labels: map[string][]string{"handler": {"b"}}},
+			stack{frames: []string{"main.main", "main.idle"}, value: 0},
+		)
+
+		mapping := &profile.Mapping{ID: 1, File: "/bin/app", Start: 0x1000, Limit: 0x2000, HasFunctions: true}
+
+		prof.Mapping = []*profile.Mapping{mapping}
+		prof.Comments = []string{"built on ci"}
+
+		for _, loc := range prof.Location {
+			loc.Mapping = mapping
+			loc.Address = 0x1000 + loc.ID
+		}
+
+		require.NoError(t, prof.CheckValid())
+
+		return prof
+	}
+
+	t.Run("given a CPU profile, then only what PGO uses is kept", func(t *testing.T) {
+		t.Parallel()
+
+		prof := newProfile(t)
+
+		minimal, err := pprof.Minimize(prof)
+		require.NoError(t, err)
+
+		require.Len(t, minimal.SampleType, 1)
+		require.Equal(t, "samples", minimal.SampleType[0].Type)
+		require.Empty(t, minimal.Mapping)
+		require.Empty(t, minimal.Comments)
+
+		// The samples that only differed by their labels are merged, and the ones without samples dropped.
+		require.Len(t, minimal.Sample, 1)
+		require.Equal(t, []int64{15}, minimal.Sample[0].Value)
+		require.Empty(t, minimal.Sample[0].Label)
+
+		for _, loc := range minimal.Location {
+			require.Zero(t, loc.Address)
+			require.Nil(t, loc.Mapping)
+			require.NotEmpty(t, loc.Line)
+		}
+
+		var original, minimized bytes.Buffer
+
+		require.NoError(t, prof.WriteUncompressed(&original))
+		require.NoError(t, minimal.WriteUncompressed(&minimized))
+		require.Less(t, minimized.Len(), original.Len())
+
+		// The caller's profile is left untouched.
+		require.Len(t, prof.Mapping, 1)
+		require.Len(t, prof.Sample, 3)
+	})
+
+	t.Run("given the minimal option, then the merged profile is minimized", func(t *testing.T) {
+		t.Parallel()
+
+		var w bytes.Buffer
+
+		require.NoError(t, pprof.MergeProfiles(&w, nil, []*profile.Profile{newProfile(t)}, pprof.MergeOptions{Minimal: true}))
+
+		merged, err := profile.Parse(&w)
+		require.NoError(t, err)
+		require.Len(t, merged.SampleType, 1)
+		require.Empty(t, merged.Mapping)
+	})
+
+	t.Run("when the profile is not a CPU profile, then an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		heapProfile := &profile.Profile{
+			PeriodType: &profile.ValueType{Type: "space", Unit: "bytes"},
+			SampleType: []*profile.ValueType{{Type: "alloc_space", Unit: "bytes"}},
+		}
+
+		_, err := pprof.Minimize(heapProfile)
+		require.ErrorIs(t, err, pprof.ErrNotCPUProfile)
+	})
+}
@@ -0,0 +1,323 @@
+package pprof
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// ExistingProfile is the index of Adjustment.Profile for the existing profile.
+const ExistingProfile = -1
+
+// Adjustment made to a profile so that it can be merged with the others, e.g. a dropped sample type.
+type Adjustment struct {
+	// Profile is the index of the adjusted profile among the new ones, or ExistingProfile.
+	Profile int
+	Reason  string
+}
+
+func (a Adjustment) String() string {
+	if a.Profile == ExistingProfile {
+		return "existing profile: " + a.Reason
+	}
+
+	return fmt.Sprintf("profile %v: %v", a.Profile, a.Reason)
+}
+
+// timeUnits maps the time units to their value in nanoseconds.
+var timeUnits = map[string]int64{
+	"nanoseconds":  1,
+	"microseconds": int64(time.Microsecond),
+	"milliseconds": int64(time.Millisecond),
+	"seconds":      int64(time.Second),
+}
+
+// normalize makes the profiles mergeable, as profile.Merge fails when their sample or period types differ.
+// The existing profile, or else the first new one, is the reference:
+//   - The sample types missing from any profile are dropped, the others are reordered and converted to the time unit
+//     of the reference.
+//   - The period type and period are converted to the ones of the reference. The counts are rescaled accordingly,
+//     e.g. 10 samples at 100Hz become 20 samples at 200Hz.
+//   - The new profiles are rescaled to the longest duration among them, e.g. when a scrape uses another `seconds`, so
+//     that every one of them weighs the same. The existing profile is not, as it holds the duration of all past merges.
+//
+// The profiles are copied before being adjusted, and every adjustment is passed to `report`, if set.
+func normalize(
+	existing *profile.Profile, profiles []*profile.Profile, report func(Adjustment),
+) (*profile.Profile, []*profile.Profile, error) {
+	n := normalizer{report: report}
+
+	if existing != nil {
+		n.profiles = append(n.profiles, existing)
+		n.indexes = append(n.indexes, ExistingProfile)
+	}
+
+	for i, prof := range profiles {
+		n.profiles = append(n.profiles, prof)
+		n.indexes = append(n.indexes, i)
+	}
+
+	if len(n.profiles) == 0 {
+		return existing, profiles, nil
+	}
+
+	n.copied = make([]bool, len(n.profiles))
+
+	if err := n.sampleTypes(); err != nil {
+		return nil, nil, err
+	}
+
+	n.periods()
+	n.durations()
+
+	if existing != nil {
+		return n.profiles[0], n.profiles[1:], nil
+	}
+
+	return nil, n.profiles, nil
+}
+
+type normalizer struct {
+	profiles []*profile.Profile
+	// indexes of the profiles for Adjustment.Profile.
+	indexes []int
+	// copied tells whether the profile was already copied, and thus can be modified.
+	copied []bool
+	report func(Adjustment)
+}
+
+// mutable returns the i-th profile, copying it the first time.
+func (n *normalizer) mutable(i int) *profile.Profile {
+	if !n.copied[i] {
+		n.profiles[i] = n.profiles[i].Copy()
+		n.copied[i] = true
+	}
+
+	return n.profiles[i]
+}
+
+func (n *normalizer) reportf(i int, format string, args ...any) {
+	if n.report != nil {
+		n.report(Adjustment{Profile: n.indexes[i], Reason: fmt.Sprintf(format, args...)})
+	}
+}
+
+// sampleTypes keeps the sample types of the reference that every profile has, in the same order and unit.
+func (n *normalizer) sampleTypes() error {
+	var common []*profile.ValueType
+
+	for _, sampleType := range n.profiles[0].SampleType {
+		shared := true
+
+		for _, prof := range n.profiles[1:] {
+			if sampleTypeIndex(prof, sampleType) < 0 {
+				shared = false
+				break
+			}
+		}
+
+		if shared {
+			common = append(common, sampleType)
+		}
+	}
+
+	if pgoSampleIndex(&profile.Profile{SampleType: common}) < 0 {
+		return fmt.Errorf("%v: %w", valueTypes(common...), ErrIncompatibleProfiles)
+	}
+
+	for i, prof := range n.profiles {
+		indexes := make([]int, len(common))
+		factors := make([]float64, len(common))
+		converted := false
+
+		for j, sampleType := range common {
+			indexes[j] = sampleTypeIndex(prof, sampleType)
+			factors[j] = unitFactor(prof.SampleType[indexes[j]].Unit, sampleType.Unit)
+			converted = converted || factors[j] != 1
+		}
+
+		if !converted && slices.Equal(indexes, identity(len(prof.SampleType))) {
+			continue
+		}
+
+		before := valueTypes(prof.SampleType...)
+
+		prof = n.mutable(i)
+
+		for _, sample := range prof.Sample {
+			values := make([]int64, len(indexes))
+
+			for j, index := range indexes {
+				values[j] = sample.Value[index]
+			}
+
+			sample.Value = values
+		}
+
+		prof.SampleType = make([]*profile.ValueType, len(common))
+
+		for j, sampleType := range common {
+			prof.SampleType[j] = copyValueType(sampleType)
+		}
+
+		prof.DefaultSampleType = ""
+
+		if converted {
+			scaleSampleTypes(prof, factors)
+		}
+
+		n.reportf(i, "sample types %v normalized to %v", before, valueTypes(prof.SampleType...))
+	}
+
+	return nil
+}
+
+// periods converts the period of the profiles to the one of the reference.
+func (n *normalizer) periods() {
+	reference := n.profiles[0]
+
+	if reference.PeriodType == nil {
+		reference = n.mutable(0)
+		reference.PeriodType = &profile.ValueType{Type: "cpu", Unit: "nanoseconds"}
+
+		n.reportf(0, "missing period type set to %v", valueTypes(reference.PeriodType))
+	}
+
+	for i := 1; i < len(n.profiles); i++ {
+		prof := n.profiles[i]
+
+		if prof.PeriodType == nil || prof.PeriodType.Type != reference.PeriodType.Type ||
+			unitFactor(prof.PeriodType.Unit, reference.PeriodType.Unit) == 0 {
+			// The period cannot be converted, so the counts cannot be either.
+			prof = n.mutable(i)
+			prof.PeriodType = copyValueType(reference.PeriodType)
+			prof.Period = reference.Period
+
+			n.reportf(i, "incompatible period type replaced by %v", valueTypes(reference.PeriodType))
+
+			continue
+		}
+
+		if prof.PeriodType.Unit == reference.PeriodType.Unit && prof.Period == reference.Period {
+			continue
+		}
+
+		period := float64(prof.Period) * unitFactor(prof.PeriodType.Unit, reference.PeriodType.Unit)
+
+		prof = n.mutable(i)
+
+		before := fmt.Sprintf("%v %v", prof.Period, prof.PeriodType.Unit)
+
+		prof.PeriodType = copyValueType(reference.PeriodType)
+		prof.Period = reference.Period
+
+		if period <= 0 || reference.Period <= 0 {
+			n.reportf(i, "period %v replaced by %v %v", before, reference.Period, reference.PeriodType.Unit)
+
+			continue
+		}
+
+		// Only the counts depend on the period, the times are absolute.
+		factors := make([]float64, len(prof.SampleType))
+
+		for j, sampleType := range prof.SampleType {
+			factors[j] = 1
+
+			if sampleType.Unit == "count" {
+				factors[j] = period / float64(reference.Period)
+			}
+		}
+
+		scaleSampleTypes(prof, factors)
+
+		n.reportf(i, "period %v converted to %v %v", before, reference.Period, reference.PeriodType.Unit)
+	}
+}
+
+// durations rescales the new profiles to the longest duration among them.
+func (n *normalizer) durations() {
+	var longest int64
+
+	for i, prof := range n.profiles {
+		if n.indexes[i] != ExistingProfile {
+			longest = max(longest, prof.DurationNanos)
+		}
+	}
+
+	for i, prof := range n.profiles {
+		// Profiles without a duration, e.g. converted ones, are left as is.
+		if n.indexes[i] == ExistingProfile || prof.DurationNanos <= 0 || prof.DurationNanos == longest {
+			continue
+		}
+
+		before := time.Duration(prof.DurationNanos)
+
+		prof = n.mutable(i)
+
+		scale(prof, float64(longest)/float64(prof.DurationNanos))
+		prof.DurationNanos = longest
+
+		n.reportf(i, "duration %v rescaled to %v", before, time.Duration(longest))
+	}
+}
+
+// sampleTypeIndex returns the index of the sample type of the profile that has the same type as `sampleType`, and a
+// convertible unit, or -1 if there is none.
+func sampleTypeIndex(prof *profile.Profile, sampleType *profile.ValueType) int {
+	for i, st := range prof.SampleType {
+		if st.Type == sampleType.Type && unitFactor(st.Unit, sampleType.Unit) != 0 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// unitFactor returns the factor converting values from one unit to the other, 1 if they are the same, or 0 if they
+// cannot be converted.
+func unitFactor(from, to string) float64 {
+	if from == to {
+		return 1
+	}
+
+	fromNanos, ok := timeUnits[from]
+	if !ok {
+		return 0
+	}
+
+	toNanos, ok := timeUnits[to]
+	if !ok {
+		return 0
+	}
+
+	return float64(fromNanos) / float64(toNanos)
+}
+
+// identity returns the indexes from 0 to n-1.
+func identity(n int) []int {
+	indexes := make([]int, n)
+
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	return indexes
+}
+
+func copyValueType(valueType *profile.ValueType) *profile.ValueType {
+	return &profile.ValueType{Type: valueType.Type, Unit: valueType.Unit}
+}
+
+// valueTypes formats the value types as type/unit, e.g. for the adjustments.
+func valueTypes(valueTypes ...*profile.ValueType) string {
+	formatted := make([]string, len(valueTypes))
+
+	for i, valueType := range valueTypes {
+		formatted[i] = valueType.Type + "/" + valueType.Unit
+	}
+
+	return "[" + strings.Join(formatted, " ") + "]"
+}
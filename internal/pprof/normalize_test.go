@@ -0,0 +1,154 @@
+package pprof_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/pprof"
+)
+
+func TestMergeProfilesNormalization(t *testing.T) {
+	t.Parallel()
+
+	// merge the profiles, returning the values of the leaves and the adjustments made.
+	merge := func(
+		t *testing.T, existing *profile.Profile, profiles ...*profile.Profile,
+	) (*profile.Profile, map[string][]int64, []pprof.Adjustment) {
+		t.Helper()
+
+		var (
+			w           bytes.Buffer
+			adjustments []pprof.Adjustment
+		)
+
+		require.NoError(t, pprof.MergeProfiles(&w, existing, profiles, pprof.MergeOptions{
+			OnAdjustment: func(adjustment pprof.Adjustment) {
+				adjustments = append(adjustments, adjustment)
+			},
+		}))
+
+		merged, err := profile.Parse(&w)
+		require.NoError(t, err)
+
+		values := make(map[string][]int64, len(merged.Sample))
+
+		for _, sample := range merged.Sample {
+			values[sample.Location[0].Line[0].Function.Name] = sample.Value
+		}
+
+		return merged, values, adjustments
+	}
+
+	t.Run("given differing sample types, then they are reordered, converted and the extras dropped", func(t *testing.T) {
+		t.Parallel()
+
+		existing := newCPUProfile(t, stack{frames: []string{"main.main", "main.old"}, value: 3})
+		newProfile := newCPUProfile(t, stack{frames: []string{"main.main", "main.new"}, value: 2})
+
+		newProfile.SampleType = []*profile.ValueType{
+			{Type: "cpu", Unit: "milliseconds"},
+			{Type: "alloc_space", Unit: "bytes"},
+			{Type: "samples", Unit: "count"},
+		}
+		newProfile.Sample[0].Value = []int64{20, 4096, 2}
+
+		merged, values, adjustments := merge(t, existing, newProfile)
+
+		require.Equal(t, existing.SampleType[0].Type, merged.SampleType[0].Type)
+		require.Equal(t, existing.SampleType[1].Unit, merged.SampleType[1].Unit)
+		require.Len(t, merged.SampleType, 2)
+		require.Equal(t, []int64{2, int64(20 * time.Millisecond)}, values["main.new"])
+		require.Equal(t, []int64{3, int64(30 * time.Millisecond)}, values["main.old"])
+
+		require.Len(t, adjustments, 1)
+		require.Equal(t, 0, adjustments[0].Profile)
+
+		// The caller's profile is left untouched.
+		require.Len(t, newProfile.Sample[0].Value, 3)
+	})
+
+	t.Run("given a sample type missing from a new profile, then it is dropped from the existing one", func(t *testing.T) {
+		t.Parallel()
+
+		existing := newCPUProfile(t, stack{frames: []string{"main.main", "main.old"}, value: 3})
+		newProfile := newCPUProfile(t, stack{frames: []string{"main.main", "main.new"}, value: 2})
+
+		newProfile.SampleType = newProfile.SampleType[1:]
+		newProfile.Sample[0].Value = newProfile.Sample[0].Value[1:]
+
+		merged, values, adjustments := merge(t, existing, newProfile)
+
+		require.Len(t, merged.SampleType, 1)
+		require.Equal(t, []int64{int64(30 * time.Millisecond)}, values["main.old"])
+
+		require.Len(t, adjustments, 1)
+		require.Equal(t, pprof.ExistingProfile, adjustments[0].Profile)
+		require.Contains(t, adjustments[0].String(), "existing profile")
+	})
+
+	t.Run("given a differing period, then the counts are converted to the period of the existing profile", func(t *testing.T) {
+		t.Parallel()
+
+		existing := newCPUProfile(t, stack{frames: []string{"main.main", "main.old"}, value: 3})
+		newProfile := newCPUProfile(t, stack{frames: []string{"main.main", "main.new"}, value: 20})
+
+		// 20 samples every 5ms are as long as 10 samples every 10ms.
+		newProfile.PeriodType.Unit = "milliseconds"
+		newProfile.Period = 5
+		newProfile.Sample[0].Value[1] = int64(100 * time.Millisecond)
+
+		merged, values, adjustments := merge(t, existing, newProfile)
+
+		require.Equal(t, existing.Period, merged.Period)
+		require.Equal(t, "nanoseconds", merged.PeriodType.Unit)
+		require.Equal(t, []int64{10, int64(100 * time.Millisecond)}, values["main.new"])
+		require.Len(t, adjustments, 1)
+	})
+
+	t.Run("given new profiles of differing durations, then they are rescaled to the longest one", func(t *testing.T) {
+		t.Parallel()
+
+		shortProfile := newCPUProfile(t, stack{frames: []string{"main.main", "main.short"}, value: 2})
+		longProfile := newCPUProfile(t, stack{frames: []string{"main.main", "main.long"}, value: 2})
+
+		shortProfile.DurationNanos = int64(10 * time.Second)
+		longProfile.DurationNanos = int64(30 * time.Second)
+
+		_, values, adjustments := merge(t, nil, shortProfile, longProfile)
+
+		require.Equal(t, []int64{6, int64(60 * time.Millisecond)}, values["main.short"])
+		require.Equal(t, []int64{2, int64(20 * time.Millisecond)}, values["main.long"])
+
+		require.Len(t, adjustments, 1)
+		require.Equal(t, 0, adjustments[0].Profile)
+	})
+
+	t.Run("given compatible profiles, then nothing is adjusted", func(t *testing.T) {
+		t.Parallel()
+
+		existing := newCPUProfile(t, stack{frames: []string{"main.main", "main.old"}, value: 3})
+		newProfile := newCPUProfile(t, stack{frames: []string{"main.main", "main.new"}, value: 2})
+
+		_, _, adjustments := merge(t, existing, newProfile)
+		require.Empty(t, adjustments)
+	})
+
+	t.Run("when the profiles have no PGO sample type in common, then an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		existing := newCPUProfile(t, stack{frames: []string{"main.main", "main.old"}, value: 3})
+		newProfile := newCPUProfile(t, stack{frames: []string{"main.main", "main.new"}, value: 2})
+
+		newProfile.SampleType = []*profile.ValueType{{Type: "alloc_space", Unit: "bytes"}}
+		newProfile.Sample[0].Value = []int64{4096}
+
+		var w bytes.Buffer
+
+		err := pprof.MergeProfiles(&w, existing, []*profile.Profile{newProfile}, pprof.MergeOptions{})
+		require.ErrorIs(t, err, pprof.ErrIncompatibleProfiles)
+	})
+}
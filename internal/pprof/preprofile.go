@@ -0,0 +1,223 @@
+package pprof
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// preprofileHeader starts the preprocessed profiles of `go tool preprofile`, which the Go toolchain detects by it.
+const preprofileHeader = "GO PREPROFILE V1\n"
+
+// WritePreprofile writes the profile in the preprocessed format of `go tool preprofile`, which the Go compiler reads
+// without parsing pprof on every build since Go 1.23. Each call edge is three lines, from the heaviest:
+//
+//	main.handle
+//	main.parse
+//	12 420
+//
+// The caller, the callee, and the offset of the call from the start of the caller followed by the weight.
+func WritePreprofile(w io.Writer, prof *profile.Profile) error {
+	index := pgoSampleIndex(prof)
+	if index < 0 {
+		return ErrNotCPUProfile
+	}
+
+	weights := map[edge]int64{}
+
+	var total int64
+
+	for _, sample := range prof.Sample {
+		for _, e := range pgoEdges(sample) {
+			weights[e] += sample.Value[index]
+			total += sample.Value[index]
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+
+	if _, err := bw.WriteString(preprofileHeader); err != nil {
+		return fmt.Errorf("bw.WriteString: %w", err)
+	}
+
+	// As the compiler, a profile without weight has no edges at all.
+	if total == 0 {
+		weights = nil
+	}
+
+	edges := make([]edge, 0, len(weights))
+
+	for e := range weights {
+		edges = append(edges, e)
+	}
+
+	sort.Slice(edges, func(i, j int) bool {
+		if weights[edges[i]] != weights[edges[j]] {
+			return weights[edges[i]] > weights[edges[j]]
+		}
+
+		return edgeLess(edges[i], edges[j])
+	})
+
+	for _, e := range edges {
+		if _, err := fmt.Fprintf(bw, "%s\n%s\n%d %d\n", e.caller, e.callee, e.offset, weights[e]); err != nil {
+			return fmt.Errorf("fmt.Fprintf: %w", err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("bw.Flush: %w", err)
+	}
+
+	return nil
+}
+
+// pgoEdges returns the call edges of the sample that the Go compiler weighs: only the ones of the two locations at
+// the bottom of the stack, including their inlined calls, each counted once.
+func pgoEdges(sample *profile.Sample) []edge {
+	locations := sample.Location
+	if len(locations) > 2 {
+		locations = locations[:2]
+	}
+
+	type frame struct {
+		address uint64
+		line    profile.Line
+	}
+
+	// From the root to the leaf, as the compiler walks them.
+	var frames []frame
+
+	for i := len(locations) - 1; i >= 0; i-- {
+		for j := len(locations[i].Line) - 1; j >= 0; j-- {
+			frames = append(frames, frame{address: locations[i].Address, line: locations[i].Line[j]})
+		}
+	}
+
+	var (
+		edges []edge
+		seen  = map[edge]bool{}
+	)
+
+	for i := 0; i+1 < len(frames); i++ {
+		caller, callee := frames[i], frames[i+1]
+
+		// The same frame twice in a row, e.g. a recursive call, is a single node of the graph.
+		if caller == callee {
+			continue
+		}
+
+		e, ok := lineEdge(caller.line, callee.line)
+		if ok && !seen[e] {
+			seen[e] = true
+			edges = append(edges, e)
+		}
+	}
+
+	return edges
+}
+
+// ParsePreprofile converts a preprocessed profile back into a CPU profile, see WritePreprofile, so that it can be
+// merged with new profiles. Each edge becomes a sample of the callee called from the caller, and its weight is the
+// sample count at DefaultConvertPeriod.
+func ParsePreprofile(r io.Reader) (*profile.Profile, error) {
+	b := newStackBuilder(ConvertOptions{})
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+
+	if !scanner.Scan() || scanner.Text()+"\n" != preprofileHeader {
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("scanner.Err: %w", err)
+		}
+
+		return nil, fmt.Errorf("%w: missing %q header", ErrUnparsableProfile, strings.TrimSpace(preprofileHeader))
+	}
+
+	for lineno := 2; ; lineno += 3 {
+		var lines [3]string
+
+		n := 0
+
+		for ; n < len(lines) && scanner.Scan(); n++ {
+			lines[n] = scanner.Text()
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("scanner.Err: %w", err)
+		}
+
+		if n == 0 {
+			break
+		}
+
+		if n < len(lines) {
+			return nil, fmt.Errorf("line %v: %w: truncated edge", lineno, ErrUnparsableProfile)
+		}
+
+		offsetField, weightField, ok := strings.Cut(lines[2], " ")
+
+		offset, offsetErr := strconv.ParseInt(offsetField, 10, 64)
+		weight, weightErr := strconv.ParseInt(weightField, 10, 64)
+
+		if !ok || offsetErr != nil || weightErr != nil || weight < 0 {
+			return nil, fmt.Errorf("line %v: %w: invalid offset and weight %q", lineno+2, ErrUnparsableProfile, lines[2])
+		}
+
+		b.addEdge(lines[0], lines[1], offset, weight)
+	}
+
+	if err := b.prof.CheckValid(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnparsableProfile, err)
+	}
+
+	return b.prof, nil
+}
+
+// addEdge adds a sample of the callee called from the caller at `offset` from the start of the caller.
+func (b *stackBuilder) addEdge(caller, callee string, offset, weight int64) {
+	sample := &profile.Sample{
+		Location: []*profile.Location{b.location(callee), b.callSite(caller, offset)},
+		Value:    []int64{weight, weight * b.period},
+	}
+
+	b.prof.Sample = append(b.prof.Sample, sample)
+}
+
+// callSite returns the location of the call at `offset` in the function, added to the profile the first time.
+func (b *stackBuilder) callSite(name string, offset int64) *profile.Location {
+	key := callSite{function: name, offset: offset}
+
+	if loc, ok := b.callSites[key]; ok {
+		return loc
+	}
+
+	loc := &profile.Location{
+		ID:   uint64(len(b.prof.Location) + 1),
+		Line: []profile.Line{{Function: b.function(name), Line: convertedStartLine + offset}},
+	}
+	b.callSites[key] = loc
+	b.prof.Location = append(b.prof.Location, loc)
+
+	return loc
+}
+
+// parseData parses a pprof profile, or a preprocessed one, detected by its header as the Go toolchain does. So the
+// existing PGO file can be merged whatever its format.
+func parseData(data []byte) (*profile.Profile, error) {
+	if isPreprofile(data) {
+		return ParsePreprofile(bytes.NewReader(data))
+	}
+
+	return profile.ParseData(data)
+}
+
+func isPreprofile(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(preprofileHeader))
+}
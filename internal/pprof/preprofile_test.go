@@ -0,0 +1,99 @@
+package pprof_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/pprof"
+)
+
+func TestWritePreprofile(t *testing.T) {
+	t.Parallel()
+
+	prof := newCPUProfile(t,
+		stack{frames: []string{"main.main", "main.a", "main.leaf"}, value: 5},
+		stack{frames: []string{"main.main", "main.b"}, value: 7},
+		stack{frames: []string{"main.main", "main.c", "main.leaf"}, value: 5},
+	)
+
+	// Only the calls into the leaves count, from the heaviest, and then by name. The calls are at line 10 of functions
+	// starting at line 1.
+	expected := "GO PREPROFILE V1\n" +
+		"main.main\nmain.b\n9 7\n" +
+		"main.a\nmain.leaf\n9 5\n" +
+		"main.c\nmain.leaf\n9 5\n"
+
+	t.Run("given a CPU profile, then its call edges are written", func(t *testing.T) {
+		t.Parallel()
+
+		var w bytes.Buffer
+
+		require.NoError(t, pprof.WritePreprofile(&w, prof))
+		require.Equal(t, expected, w.String())
+	})
+
+	t.Run("given a preprocessed profile, then it is converted back into the same call edges", func(t *testing.T) {
+		t.Parallel()
+
+		parsed, err := pprof.ParsePreprofile(strings.NewReader(expected))
+		require.NoError(t, err)
+
+		var w bytes.Buffer
+
+		require.NoError(t, pprof.WritePreprofile(&w, parsed))
+		require.Equal(t, expected, w.String())
+
+		// It is detected by its header, as the existing PGO file may be in either format.
+		parsed, err = pprof.ParseCPUProfile([]byte(expected))
+		require.NoError(t, err)
+		require.Len(t, parsed.Sample, 3)
+
+		parsed, err = pprof.FormatPreprofile.Parse([]byte(expected))
+		require.NoError(t, err)
+		require.Len(t, parsed.Sample, 3)
+	})
+
+	t.Run("given the output options, then the merged profile is written in the preprocessed format", func(t *testing.T) {
+		t.Parallel()
+
+		var w, companion bytes.Buffer
+
+		require.NoError(t, pprof.MergeProfiles(&w, nil, []*profile.Profile{prof}, pprof.MergeOptions{
+			Format: pprof.FormatPreprofile,
+		}))
+		require.Equal(t, expected, w.String())
+
+		w.Reset()
+
+		require.NoError(t, pprof.MergeProfiles(&w, nil, []*profile.Profile{prof}, pprof.MergeOptions{
+			Companion: &companion,
+		}))
+		require.Equal(t, expected, companion.String())
+
+		_, err := profile.Parse(&w)
+		require.NoError(t, err)
+
+		err = pprof.MergeProfiles(&w, nil, []*profile.Profile{prof}, pprof.MergeOptions{Format: pprof.FormatFolded})
+		require.ErrorIs(t, err, pprof.ErrUnsupportedFormat)
+	})
+
+	t.Run("when the preprocessed profile is invalid, then an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		testcases := []string{
+			"",
+			"GO PREPROFILE V2\n",
+			"GO PREPROFILE V1\nmain.main\nmain.b\n",
+			"GO PREPROFILE V1\nmain.main\nmain.b\n9 heavy\n",
+		}
+
+		for _, tt := range testcases {
+			_, err := pprof.ParsePreprofile(strings.NewReader(tt))
+			require.ErrorIs(t, err, pprof.ErrUnparsableProfile, tt)
+		}
+	})
+}
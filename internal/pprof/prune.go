@@ -0,0 +1,234 @@
+package pprof
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+type PruneOptions struct {
+	// Threshold drops the coldest samples and call edges that together make up less than this share of the total
+	// weight, between 0 and 1, e.g. 0.01 keeps what the Go compiler considers hot with its default 99% threshold.
+	Threshold float64
+	// MaxSize caps the uncompressed size of the profile in bytes, keeping the hottest samples.
+	MaxSize int64
+}
+
+// Prune drops the insignificant samples of the profile, see PruneOptions, and the locations and functions that are
+// not used anymore. The profile is not modified.
+func Prune(prof *profile.Profile, opts PruneOptions) (*profile.Profile, error) {
+	if opts.Threshold < 0 || opts.Threshold >= 1 {
+		return nil, fmt.Errorf("%v: %w", opts.Threshold, ErrInvalidThreshold)
+	}
+
+	index := pgoSampleIndex(prof)
+	if index < 0 {
+		return nil, ErrNotCPUProfile
+	}
+
+	prof = prof.Copy()
+
+	if opts.Threshold > 0 {
+		pruneSamples(prof, index, opts.Threshold)
+		pruneEdges(prof, index, opts.Threshold)
+	}
+
+	// Identical samples are merged as well, e.g. the ones truncated at the same edge.
+	prof = prof.Compact()
+
+	if opts.MaxSize > 0 {
+		return capSize(prof, index, opts.MaxSize)
+	}
+
+	return prof, nil
+}
+
+// pruneSamples drops the coldest samples that together make up less than `threshold` of the total weight.
+func pruneSamples(prof *profile.Profile, index int, threshold float64) {
+	sortByWeight(prof, index)
+
+	var total int64
+
+	for _, sample := range prof.Sample {
+		total += sample.Value[index]
+	}
+
+	budget := int64(threshold * float64(total))
+
+	kept := len(prof.Sample)
+
+	for kept > 0 && prof.Sample[kept-1].Value[index] <= budget {
+		budget -= prof.Sample[kept-1].Value[index]
+		kept--
+	}
+
+	prof.Sample = prof.Sample[:kept]
+}
+
+// edge of the call graph, identified as the Go compiler does.
+type edge struct {
+	caller, callee string
+	// offset of the line of the call from the start of the caller, so that it survives unrelated changes above it.
+	offset int64
+}
+
+// pruneEdges truncates the stacks at the coldest call edges that together make up less than `threshold` of the total
+// weight of the edges, keeping the frames below them. Only the edges between locations can be cut, the ones between
+// inlined functions of the same location are kept.
+func pruneEdges(prof *profile.Profile, index int, threshold float64) {
+	weights := callGraph(prof, index)
+
+	var total int64
+
+	edges := make([]edge, 0, len(weights))
+
+	for e, weight := range weights {
+		edges = append(edges, e)
+		total += weight
+	}
+
+	// The ties are broken by name, so that the same edges are cut on every run.
+	sort.Slice(edges, func(i, j int) bool {
+		if weights[edges[i]] != weights[edges[j]] {
+			return weights[edges[i]] < weights[edges[j]]
+		}
+
+		return edgeLess(edges[i], edges[j])
+	})
+
+	budget := int64(threshold * float64(total))
+	cold := map[edge]bool{}
+
+	for _, e := range edges {
+		if weights[e] > budget {
+			break
+		}
+
+		budget -= weights[e]
+		cold[e] = true
+	}
+
+	for _, sample := range prof.Sample {
+		// From the leaf to the root, so the hot frames at the bottom of the stack are kept.
+		for i := 0; i+1 < len(sample.Location); i++ {
+			e, ok := locationEdge(sample.Location[i], sample.Location[i+1])
+			if ok && cold[e] {
+				sample.Location = sample.Location[:i+1]
+				break
+			}
+		}
+	}
+}
+
+// sampleEdges returns the call edges of the sample, from the leaf to the root, including the inlined calls.
+func sampleEdges(sample *profile.Sample) []edge {
+	var edges []edge
+
+	for i, loc := range sample.Location {
+		// The inlined calls are listed from the callee to the caller.
+		for j := 0; j+1 < len(loc.Line); j++ {
+			if e, ok := lineEdge(loc.Line[j+1], loc.Line[j]); ok {
+				edges = append(edges, e)
+			}
+		}
+
+		if i+1 < len(sample.Location) {
+			if e, ok := locationEdge(loc, sample.Location[i+1]); ok {
+				edges = append(edges, e)
+			}
+		}
+	}
+
+	return edges
+}
+
+// locationEdge returns the edge from the outermost function of the caller location into the callee location.
+func locationEdge(callee, caller *profile.Location) (edge, bool) {
+	if len(callee.Line) == 0 || len(caller.Line) == 0 {
+		return edge{}, false
+	}
+
+	return lineEdge(caller.Line[0], callee.Line[len(callee.Line)-1])
+}
+
+func lineEdge(caller, callee profile.Line) (edge, bool) {
+	if caller.Function == nil || callee.Function == nil {
+		return edge{}, false
+	}
+
+	return edge{
+		caller: caller.Function.Name,
+		callee: callee.Function.Name,
+		offset: caller.Line - caller.Function.StartLine,
+	}, true
+}
+
+func edgeLess(a, b edge) bool {
+	if a.caller != b.caller {
+		return a.caller < b.caller
+	}
+
+	if a.callee != b.callee {
+		return a.callee < b.callee
+	}
+
+	return a.offset < b.offset
+}
+
+// capSize keeps the most of the hottest samples that fit into `maxSize` bytes once encoded.
+func capSize(prof *profile.Profile, index int, maxSize int64) (*profile.Profile, error) {
+	sortByWeight(prof, index)
+
+	samples := prof.Sample
+
+	// fits reports whether the profile with the n hottest samples fits, returning it compacted.
+	fits := func(n int) (*profile.Profile, bool, error) {
+		prof.Sample = samples[:n]
+		compacted := prof.Compact()
+
+		var b bytes.Buffer
+
+		if err := compacted.WriteUncompressed(&b); err != nil {
+			return nil, false, fmt.Errorf("profile.WriteUncompressed: %w", err)
+		}
+
+		return compacted, int64(b.Len()) <= maxSize, nil
+	}
+
+	capped, ok, err := fits(len(samples))
+	if err != nil || ok {
+		return capped, err
+	}
+
+	// The largest number of samples that fits, as the size grows with it.
+	n := sort.Search(len(samples), func(n int) bool {
+		_, ok, fitErr := fits(n + 1)
+		if fitErr != nil {
+			err = fitErr
+		}
+
+		return !ok
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if n == 0 {
+		return nil, fmt.Errorf("%v bytes: %w", maxSize, ErrMaxSizeTooSmall)
+	}
+
+	capped, _, err = fits(n)
+
+	return capped, err
+}
+
+// sortByWeight sorts the samples from the hottest to the coldest. The ties are kept in order, so that the same
+// samples are dropped on every run.
+func sortByWeight(prof *profile.Profile, index int) {
+	sort.SliceStable(prof.Sample, func(i, j int) bool {
+		return prof.Sample[i].Value[index] > prof.Sample[j].Value[index]
+	})
+}
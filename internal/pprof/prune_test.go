@@ -0,0 +1,110 @@
+package pprof_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/pprof"
+)
+
+func TestPrune(t *testing.T) {
+	t.Parallel()
+
+	functionNames := func(prof *profile.Profile) []string {
+		var names []string
+
+		for _, fn := range prof.Function {
+			names = append(names, fn.Name)
+		}
+
+		return names
+	}
+
+	t.Run("given a threshold, then the coldest samples are dropped with their functions", func(t *testing.T) {
+		t.Parallel()
+
+		prof := newCPUProfile(t,
+			stack{frames: []string{"main.main", "main.hot"}, value: 990},
+			stack{frames: []string{"main.main", "main.cold"}, value: 10},
+		)
+
+		pruned, err := pprof.Prune(prof, pprof.PruneOptions{Threshold: 0.01})
+		require.NoError(t, err)
+
+		require.Len(t, pruned.Sample, 1)
+		require.EqualValues(t, 990, pruned.Sample[0].Value[0])
+		require.ElementsMatch(t, []string{"main.main", "main.hot"}, functionNames(pruned))
+
+		// The caller's profile is left untouched.
+		require.Len(t, prof.Sample, 2)
+	})
+
+	t.Run("given a threshold, then the stacks are truncated at the coldest call edges", func(t *testing.T) {
+		t.Parallel()
+
+		prof := newCPUProfile(t,
+			stack{frames: []string{"main.main", "main.a", "main.b", "main.c", "main.leaf"}, value: 100},
+			stack{frames: []string{"main.rare", "main.leaf"}, value: 3},
+		)
+
+		// The rare sample is above the 2% of the samples, but its edge is below the 2% of the edges.
+		pruned, err := pprof.Prune(prof, pprof.PruneOptions{Threshold: 0.02})
+		require.NoError(t, err)
+
+		require.Len(t, pruned.Sample, 2)
+		require.NotContains(t, functionNames(pruned), "main.rare")
+
+		for _, sample := range pruned.Sample {
+			if sample.Value[0] == 3 {
+				require.Len(t, sample.Location, 1)
+				require.Equal(t, "main.leaf", sample.Location[0].Line[0].Function.Name)
+			}
+		}
+	})
+
+	t.Run("given a maximum size, then the hottest samples that fit are kept", func(t *testing.T) {
+		t.Parallel()
+
+		var stacks []stack
+
+		for i := range 100 {
+			stacks = append(stacks, stack{frames: []string{"main.main", fmt.Sprintf("main.work%03d", i)}, value: int64(i + 1)})
+		}
+
+		prof := newCPUProfile(t, stacks...)
+
+		var full bytes.Buffer
+
+		require.NoError(t, prof.WriteUncompressed(&full))
+
+		maxSize := int64(full.Len() / 2)
+
+		pruned, err := pprof.Prune(prof, pprof.PruneOptions{MaxSize: maxSize})
+		require.NoError(t, err)
+
+		var b bytes.Buffer
+
+		require.NoError(t, pruned.WriteUncompressed(&b))
+		require.LessOrEqual(t, int64(b.Len()), maxSize)
+		require.Less(t, len(pruned.Sample), 100)
+		require.Greater(t, len(pruned.Sample), 10)
+		require.Contains(t, functionNames(pruned), "main.work099")
+		require.NotContains(t, functionNames(pruned), "main.work000")
+	})
+
+	t.Run("when the options are invalid, then an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		prof := newCPUProfile(t, stack{frames: []string{"main.main"}, value: 1})
+
+		_, err := pprof.Prune(prof, pprof.PruneOptions{Threshold: 1})
+		require.ErrorIs(t, err, pprof.ErrInvalidThreshold)
+
+		_, err = pprof.Prune(prof, pprof.PruneOptions{MaxSize: 10})
+		require.ErrorIs(t, err, pprof.ErrMaxSizeTooSmall)
+	})
+}
@@ -0,0 +1,119 @@
+package pprof
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/macabu/cpgo/internal/continuous"
+	"github.com/macabu/cpgo/internal/retry"
+	"github.com/macabu/cpgo/internal/store"
+)
+
+// DefaultQueryRange covers a whole day, so the profile is representative of the daily traffic patterns.
+const DefaultQueryRange = 24 * time.Hour
+
+type QuerySourceOptions struct {
+	// ProfileType of the CPU profiles, defaults to the one of the server kind.
+	ProfileType string
+	// Selector of the labels of the service, e.g. {service_name="checkout"}.
+	Selector string
+	// Range of time until now that is queried, defaults to DefaultQueryRange.
+	Range time.Duration
+	// Checkpoint keeps the end of the range queried by the last run, so that the next one only queries from there on,
+	// as the same profiles would otherwise be merged again on every run. The range bounds how far back it goes.
+	Checkpoint *store.Checkpoint
+	// Retry policy for querying the server.
+	Retry retry.Policy
+}
+
+// QuerySource pulls the profile aggregated by a continuous profiling server, e.g. Pyroscope or Parca.
+type QuerySource struct {
+	querier continuous.Querier
+	opts    QuerySourceOptions
+	now     func() time.Time
+	// pending is the end of the range queried, saved into the checkpoint on Commit.
+	pending *queryCursor
+}
+
+type queryCursor struct {
+	End time.Time `json:"end"`
+}
+
+func NewQuerySource(querier continuous.Querier, opts QuerySourceOptions) *QuerySource {
+	if opts.Range <= 0 {
+		opts.Range = DefaultQueryRange
+	}
+
+	return &QuerySource{
+		querier: querier,
+		opts:    opts,
+		now:     time.Now,
+	}
+}
+
+// Profiles returns the single profile merged by the server over the range until now, starting from the end of the
+// range queried by the last run if more recent.
+func (s *QuerySource) Profiles(ctx context.Context) ([]*profile.Profile, error) {
+	end := s.now()
+
+	query := continuous.Query{
+		ProfileType: s.opts.ProfileType,
+		Selector:    s.opts.Selector,
+		Start:       end.Add(-s.opts.Range),
+		End:         end,
+	}
+
+	if s.opts.Checkpoint != nil {
+		var cursor queryCursor
+
+		if _, err := s.opts.Checkpoint.Load(&cursor); err != nil {
+			return nil, fmt.Errorf("checkpoint.Load: %w", err)
+		}
+
+		if cursor.End.After(query.Start) {
+			query.Start = cursor.End
+		}
+	}
+
+	var data []byte
+
+	err := retry.Do(ctx, s.opts.Retry, func(ctx context.Context) error {
+		var err error
+
+		data, err = s.querier.MergedProfile(ctx, query)
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("querier.MergedProfile: %w", err)
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%v: %w", query.Selector, continuous.ErrEmptyProfile)
+	}
+
+	prof, err := ParseCPUProfile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s.pending = &queryCursor{End: end}
+
+	return []*profile.Profile{prof}, nil
+}
+
+// Commit saves the end of the range queried into the checkpoint, once its profile was merged.
+func (s *QuerySource) Commit() error {
+	if s.opts.Checkpoint == nil || s.pending == nil {
+		return nil
+	}
+
+	if err := s.opts.Checkpoint.Save(s.pending); err != nil {
+		return fmt.Errorf("checkpoint.Save: %w", err)
+	}
+
+	return nil
+}
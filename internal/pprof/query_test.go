@@ -0,0 +1,145 @@
+package pprof_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/continuous"
+	"github.com/macabu/cpgo/internal/pprof"
+	"github.com/macabu/cpgo/internal/retry"
+	"github.com/macabu/cpgo/internal/store"
+)
+
+// fakeQuerier stands in for a continuous profiling server, answering with the responses in order.
+type fakeQuerier struct {
+	responses []fakeResponse
+	queries   []continuous.Query
+}
+
+type fakeResponse struct {
+	data []byte
+	err  error
+}
+
+func (q *fakeQuerier) MergedProfile(_ context.Context, query continuous.Query) ([]byte, error) {
+	q.queries = append(q.queries, query)
+
+	resp := q.responses[0]
+	q.responses = q.responses[1:]
+
+	return resp.data, resp.err
+}
+
+func TestQuerySource(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cpuProfile := encode(t, newCPUProfile(t, stack{frames: []string{"main.main", "main.work"}, value: 10}))
+
+	t.Run("given a selector, then it queries the last day by default", func(t *testing.T) {
+		t.Parallel()
+
+		querier := &fakeQuerier{responses: []fakeResponse{{data: cpuProfile}}}
+
+		profiles, err := pprof.NewQuerySource(querier, pprof.QuerySourceOptions{Selector: `{service_name="checkout"}`}).Profiles(ctx)
+		require.NoError(t, err)
+		require.Len(t, profiles, 1)
+
+		require.Len(t, querier.queries, 1)
+		require.Equal(t, `{service_name="checkout"}`, querier.queries[0].Selector)
+		require.Equal(t, pprof.DefaultQueryRange, querier.queries[0].End.Sub(querier.queries[0].Start))
+		require.WithinDuration(t, time.Now(), querier.queries[0].End, time.Minute)
+	})
+
+	t.Run("when the server fails transiently, then the query is retried", func(t *testing.T) {
+		t.Parallel()
+
+		querier := &fakeQuerier{responses: []fakeResponse{
+			{err: &continuous.Error{StatusCode: 503}},
+			{data: cpuProfile},
+		}}
+
+		source := pprof.NewQuerySource(querier, pprof.QuerySourceOptions{
+			Range: time.Hour,
+			Retry: retry.Policy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+		})
+
+		profiles, err := source.Profiles(ctx)
+		require.NoError(t, err)
+		require.Len(t, profiles, 1)
+		require.Len(t, querier.queries, 2)
+		require.Equal(t, time.Hour, querier.queries[1].End.Sub(querier.queries[1].Start))
+	})
+
+	t.Run("given a checkpoint, then each run queries from the end of the last committed one", func(t *testing.T) {
+		t.Parallel()
+
+		checkpoint, err := store.NewCheckpoint(filepath.Join(t.TempDir(), "checkout.json"))
+		require.NoError(t, err)
+
+		querier := &fakeQuerier{responses: []fakeResponse{{data: cpuProfile}, {data: cpuProfile}, {data: cpuProfile}}}
+
+		newSource := func() *pprof.QuerySource {
+			return pprof.NewQuerySource(querier, pprof.QuerySourceOptions{Range: time.Hour, Checkpoint: checkpoint})
+		}
+
+		_, err = newSource().Profiles(ctx)
+		require.NoError(t, err)
+
+		// The first run was not committed, e.g. it failed to open the PR, so the range is queried again.
+		source := newSource()
+
+		_, err = source.Profiles(ctx)
+		require.NoError(t, err)
+		require.Equal(t, time.Hour, querier.queries[1].End.Sub(querier.queries[1].Start))
+		require.NoError(t, source.Commit())
+
+		_, err = newSource().Profiles(ctx)
+		require.NoError(t, err)
+		require.True(t, querier.queries[1].End.Equal(querier.queries[2].Start))
+	})
+
+	errServer := errors.New("server error")
+
+	testcases := []struct {
+		name        string
+		response    fakeResponse
+		expectedErr error
+	}{
+		{
+			name:        "when no profiles match, then an error is returned",
+			response:    fakeResponse{},
+			expectedErr: continuous.ErrEmptyProfile,
+		},
+		{
+			name:        "when the response is not a profile, then an error is returned",
+			response:    fakeResponse{data: []byte("garbage")},
+			expectedErr: pprof.ErrUnparsableProfile,
+		},
+		{
+			name:        "when the server fails, then the error is returned",
+			response:    fakeResponse{err: errServer},
+			expectedErr: errServer,
+		},
+	}
+
+	for _, tt := range testcases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			querier := &fakeQuerier{responses: []fakeResponse{tt.response}}
+
+			profiles, err := pprof.NewQuerySource(querier, pprof.QuerySourceOptions{}).Profiles(ctx)
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.Nil(t, profiles)
+		})
+	}
+}
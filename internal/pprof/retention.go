@@ -0,0 +1,112 @@
+package pprof
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/macabu/cpgo/internal/store"
+)
+
+type RetentionOptions struct {
+	// MaxProfiles kept in the window, unbounded when zero.
+	MaxProfiles int
+	// MaxAge of the profiles kept in the window, unbounded when zero.
+	MaxAge time.Duration
+}
+
+// Retention keeps the raw profiles of a sliding window in a store.Store, so the PGO file is rebuilt from the
+// recent profiles only, instead of accumulating all of them since the first run.
+type Retention struct {
+	store *store.Store
+	opts  RetentionOptions
+	// pending are the new profiles of the last call to Window, only retained on Commit.
+	pending []*profile.Profile
+}
+
+func NewRetention(s *store.Store, opts RetentionOptions) *Retention {
+	return &Retention{
+		store: s,
+		opts:  opts,
+	}
+}
+
+// Window returns the retained profiles that are still in the window, followed by the new ones. The new profiles are
+// only retained on Commit, once merged, so that a failed run does not retain them twice when retried.
+// The profiles that could be loaded are returned even if others failed, alongside the joined errors.
+func (r *Retention) Window(_ context.Context, profiles []*profile.Profile) ([]*profile.Profile, error) {
+	if r.opts.MaxProfiles > 0 && len(profiles) > r.opts.MaxProfiles {
+		profiles = profiles[len(profiles)-r.opts.MaxProfiles:]
+	}
+
+	r.pending = profiles
+
+	entries, err := r.store.List()
+	if err != nil {
+		return nil, fmt.Errorf("store.List: %w", err)
+	}
+
+	kept, _ := r.split(entries, len(profiles))
+
+	retained, _, err := loadEntries(r.store, kept)
+
+	return append(retained, profiles...), err
+}
+
+// Commit retains the new profiles of the last call to Window, and evicts the ones that fell out of the window.
+func (r *Retention) Commit() error {
+	for len(r.pending) > 0 {
+		if err := putProfile(r.store, r.pending[0]); err != nil {
+			return fmt.Errorf("putProfile: %w", err)
+		}
+
+		r.pending = r.pending[1:]
+	}
+
+	return r.Evict()
+}
+
+// Evict the retained profiles that fell out of the window, e.g. as they are too old.
+func (r *Retention) Evict() error {
+	entries, err := r.store.List()
+	if err != nil {
+		return fmt.Errorf("store.List: %w", err)
+	}
+
+	_, evicted := r.split(entries, 0)
+
+	if err := r.store.Remove(evicted...); err != nil {
+		return fmt.Errorf("store.Remove: %w", err)
+	}
+
+	return nil
+}
+
+// split the entries, sorted from the oldest, into the ones kept in the window and the names of the ones evicted,
+// once `added` new profiles are retained.
+func (r *Retention) split(entries []store.Entry, added int) ([]store.Entry, []string) {
+	var evicted []string
+
+	if r.opts.MaxAge > 0 {
+		oldest := time.Now().Add(-r.opts.MaxAge)
+
+		for len(entries) > 0 && entries[0].ModTime.Before(oldest) {
+			evicted = append(evicted, entries[0].Name)
+			entries = entries[1:]
+		}
+	}
+
+	if r.opts.MaxProfiles > 0 && len(entries)+added > r.opts.MaxProfiles {
+		excess := min(len(entries)+added-r.opts.MaxProfiles, len(entries))
+
+		for _, entry := range entries[:excess] {
+			evicted = append(evicted, entry.Name)
+		}
+
+		entries = entries[excess:]
+	}
+
+	return entries, evicted
+}
@@ -0,0 +1,129 @@
+package pprof_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/pprof"
+	"github.com/macabu/cpgo/internal/store"
+)
+
+func TestRetention(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cpuProfile := newCPUProfile(t, stack{frames: []string{"main.main", "main.work"}, value: 10})
+
+	t.Run("given a maximum of profiles, then only the newest ones are kept", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := store.New(t.TempDir())
+		require.NoError(t, err)
+
+		retention := pprof.NewRetention(s, pprof.RetentionOptions{MaxProfiles: 3})
+
+		for run := 1; run <= 3; run++ {
+			window, err := retention.Window(ctx, []*profile.Profile{cpuProfile, cpuProfile})
+			require.NoError(t, err)
+			require.Len(t, window, min(2*run, 3))
+
+			require.NoError(t, retention.Commit())
+		}
+
+		entries, err := s.List()
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+	})
+
+	t.Run("given a maximum age, then older profiles are evicted", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := store.New(t.TempDir())
+		require.NoError(t, err)
+
+		retention := pprof.NewRetention(s, pprof.RetentionOptions{MaxAge: 24 * time.Hour})
+
+		_, err = retention.Window(ctx, []*profile.Profile{cpuProfile})
+		require.NoError(t, err)
+		require.NoError(t, retention.Commit())
+
+		entries, err := s.List()
+		require.NoError(t, err)
+
+		twoDaysAgo := time.Now().Add(-48 * time.Hour)
+		require.NoError(t, os.Chtimes(entries[0].Path, twoDaysAgo, twoDaysAgo))
+
+		window, err := retention.Window(ctx, []*profile.Profile{cpuProfile})
+		require.NoError(t, err)
+		require.Len(t, window, 1)
+		require.NoError(t, retention.Commit())
+
+		entries, err = s.List()
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+	})
+
+	t.Run("given no new profiles, then the outdated ones are evicted nonetheless", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := store.New(t.TempDir())
+		require.NoError(t, err)
+
+		retention := pprof.NewRetention(s, pprof.RetentionOptions{MaxAge: 24 * time.Hour})
+
+		_, err = retention.Window(ctx, []*profile.Profile{cpuProfile, cpuProfile})
+		require.NoError(t, err)
+		require.NoError(t, retention.Commit())
+
+		entries, err := s.List()
+		require.NoError(t, err)
+
+		twoDaysAgo := time.Now().Add(-48 * time.Hour)
+		require.NoError(t, os.Chtimes(entries[0].Path, twoDaysAgo, twoDaysAgo))
+
+		require.NoError(t, retention.Evict())
+
+		entries, err = s.List()
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+	})
+
+	t.Run("given a failed run, then its profiles are only retained once when retried", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := store.New(t.TempDir())
+		require.NoError(t, err)
+
+		retention := pprof.NewRetention(s, pprof.RetentionOptions{})
+
+		// The run fails before committing, e.g. when updating the PGO file.
+		window, err := retention.Window(ctx, []*profile.Profile{cpuProfile})
+		require.NoError(t, err)
+		require.Len(t, window, 1)
+
+		entries, err := s.List()
+		require.NoError(t, err)
+		require.Empty(t, entries)
+
+		// The retried run fetches the same profile again.
+		window, err = retention.Window(ctx, []*profile.Profile{cpuProfile})
+		require.NoError(t, err)
+		require.Len(t, window, 1)
+		require.NoError(t, retention.Commit())
+
+		entries, err = s.List()
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+
+		// The next run rebuilds the window from the retained profile.
+		window, err = retention.Window(ctx, nil)
+		require.NoError(t, err)
+		require.Len(t, window, 1)
+	})
+}
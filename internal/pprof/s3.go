@@ -0,0 +1,193 @@
+package pprof
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/macabu/cpgo/internal/retry"
+	"github.com/macabu/cpgo/internal/s3"
+	"github.com/macabu/cpgo/internal/store"
+)
+
+type S3SourceOptions struct {
+	Bucket string
+	Prefix string
+	// Checkpoint keeps track of the objects consumed, so that only the newer ones are loaded, even after a restart.
+	// All objects are loaded on every run when nil.
+	Checkpoint *store.Checkpoint
+	// MaxBodySize in bytes of a profile, defaults to DefaultMaxBodySize.
+	MaxBodySize int64
+	// Retry policy for listing and downloading the objects.
+	Retry retry.Policy
+	// Format of the objects, defaults to FormatPprof.
+	Format Format
+}
+
+// S3Source loads the profiles stored under a prefix of an S3 compatible bucket.
+type S3Source struct {
+	client *s3.Client
+	opts   S3SourceOptions
+	// pending is the cursor after the objects loaded, saved into the checkpoint on Commit.
+	pending *s3Cursor
+}
+
+// s3Cursor is the position after the last object consumed, in the order they were modified.
+type s3Cursor struct {
+	LastModified time.Time `json:"last_modified"`
+	// Keys consumed that were modified at LastModified, as its granularity is a second and more objects may be
+	// uploaded within the same second after they were listed.
+	Keys []string `json:"keys"`
+}
+
+// consumed reports whether the object is at or before the cursor.
+func (c s3Cursor) consumed(object s3.Object) bool {
+	return object.LastModified.Before(c.LastModified) ||
+		(object.LastModified.Equal(c.LastModified) && slices.Contains(c.Keys, object.Key))
+}
+
+// advance the cursor past the object, which is not before it.
+func (c *s3Cursor) advance(object s3.Object) {
+	if object.LastModified.After(c.LastModified) {
+		c.LastModified = object.LastModified
+		c.Keys = nil
+	}
+
+	c.Keys = append(c.Keys, object.Key)
+}
+
+func NewS3Source(client *s3.Client, opts S3SourceOptions) *S3Source {
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
+
+	return &S3Source{
+		client: client,
+		opts:   opts,
+	}
+}
+
+// Profiles downloads every object under the prefix that was not consumed yet, from the oldest one. When an object
+// cannot be downloaded for now, it and the newer ones are left for the next run, so the checkpoint never skips it.
+func (s *S3Source) Profiles(ctx context.Context) ([]*profile.Profile, error) {
+	var cursor s3Cursor
+
+	if s.opts.Checkpoint != nil {
+		if _, err := s.opts.Checkpoint.Load(&cursor); err != nil {
+			return nil, fmt.Errorf("checkpoint.Load: %w", err)
+		}
+	}
+
+	var objects []s3.Object
+
+	err := retry.Do(ctx, s.opts.Retry, func(ctx context.Context) error {
+		var err error
+
+		objects, err = s.client.ListObjects(ctx, s.opts.Bucket, s.opts.Prefix)
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("client.ListObjects: %w", err)
+	}
+
+	slices.SortStableFunc(objects, func(a, b s3.Object) int {
+		return a.LastModified.Compare(b.LastModified)
+	})
+
+	var (
+		profiles []*profile.Profile
+		errs     []error
+	)
+
+	for _, object := range objects {
+		if cursor.consumed(object) {
+			continue
+		}
+
+		// Objects too large or not profiles would fail again, so they are consumed anyway.
+		if object.Size > s.opts.MaxBodySize {
+			errs = append(errs, fmt.Errorf("%v: %w", object.Key, ErrBodyTooLarge))
+			cursor.advance(object)
+
+			continue
+		}
+
+		data, err := s.download(ctx, object.Key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", object.Key, err))
+
+			if !retry.IsPermanent(err) && s.opts.Checkpoint != nil {
+				break
+			}
+		}
+
+		cursor.advance(object)
+
+		if err != nil {
+			continue
+		}
+
+		prof, err := s.opts.Format.Parse(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", object.Key, err))
+
+			continue
+		}
+
+		profiles = append(profiles, prof)
+	}
+
+	s.pending = &cursor
+
+	return profiles, errors.Join(errs...)
+}
+
+// Commit saves the cursor after the objects loaded into the checkpoint, once they were merged.
+func (s *S3Source) Commit() error {
+	if s.opts.Checkpoint == nil || s.pending == nil {
+		return nil
+	}
+
+	if err := s.opts.Checkpoint.Save(s.pending); err != nil {
+		return fmt.Errorf("checkpoint.Save: %w", err)
+	}
+
+	return nil
+}
+
+// download the object stored at `key`.
+func (s *S3Source) download(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+
+	err := retry.Do(ctx, s.opts.Retry, func(ctx context.Context) error {
+		body, err := s.client.GetObject(ctx, s.opts.Bucket, key)
+		if err != nil {
+			return err
+		}
+
+		defer body.Close()
+
+		// The listed size may be outdated if the object was overwritten in the meantime.
+		data, err = io.ReadAll(io.LimitReader(body, s.opts.MaxBodySize+1))
+		if err != nil {
+			return fmt.Errorf("io.ReadAll: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("client.GetObject: %w", err)
+	}
+
+	if int64(len(data)) > s.opts.MaxBodySize {
+		return nil, retry.Permanent(ErrBodyTooLarge)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,202 @@
+package pprof_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/pprof"
+	"github.com/macabu/cpgo/internal/s3"
+	"github.com/macabu/cpgo/internal/store"
+)
+
+type bucketObject struct {
+	key          string
+	lastModified time.Time
+	content      []byte
+	// unavailable objects are listed, but cannot be downloaded for now.
+	unavailable bool
+}
+
+// newFakeBucket serves the objects with the S3 API, standing in for MinIO.
+func newFakeBucket(t *testing.T, objects ...bucketObject) *s3.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/profiles" {
+			var contents strings.Builder
+
+			for _, object := range objects {
+				if !strings.HasPrefix(object.key, r.URL.Query().Get("prefix")) {
+					continue
+				}
+
+				fmt.Fprintf(&contents, "<Contents><Key>%v</Key><LastModified>%v</LastModified><Size>%v</Size></Contents>",
+					object.key, object.lastModified.Format(time.RFC3339), len(object.content))
+			}
+
+			fmt.Fprintf(w, "<ListBucketResult><IsTruncated>false</IsTruncated>%v</ListBucketResult>", contents.String())
+
+			return
+		}
+
+		for _, object := range objects {
+			if r.URL.Path == "/profiles/"+object.key && object.unavailable {
+				w.WriteHeader(http.StatusServiceUnavailable)
+
+				return
+			}
+
+			if r.URL.Path == "/profiles/"+object.key {
+				_, _ = w.Write(object.content)
+
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(srv.Close)
+
+	return s3.NewClient(srv.Client(), s3.Options{Endpoint: srv.URL, PathStyle: true})
+}
+
+func TestS3Source(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cpuProfile := encode(t, newCPUProfile(t, stack{frames: []string{"main.main", "main.work"}, value: 10}))
+
+	lastRun := time.Date(2023, time.August, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("given objects under the prefix, then it loads all of them", func(t *testing.T) {
+		t.Parallel()
+
+		client := newFakeBucket(t,
+			bucketObject{key: "checkout/1.pprof", lastModified: lastRun.Add(-time.Hour), content: cpuProfile},
+			bucketObject{key: "checkout/2.pprof", lastModified: lastRun.Add(time.Hour), content: cpuProfile},
+			bucketObject{key: "payments/1.pprof", lastModified: lastRun.Add(time.Hour), content: cpuProfile},
+		)
+
+		profiles, err := pprof.NewS3Source(client, pprof.S3SourceOptions{Bucket: "profiles", Prefix: "checkout/"}).Profiles(ctx)
+		require.NoError(t, err)
+		require.Len(t, profiles, 2)
+	})
+
+	t.Run("given a checkpoint, then only the objects not committed yet are loaded, even after a restart", func(t *testing.T) {
+		t.Parallel()
+
+		objects := []bucketObject{
+			{key: "checkout/1.pprof", lastModified: lastRun.Add(-time.Hour), content: cpuProfile},
+			{key: "checkout/2.pprof", lastModified: lastRun, content: cpuProfile},
+		}
+
+		path := filepath.Join(t.TempDir(), "checkout.json")
+
+		newSource := func(objects ...bucketObject) *pprof.S3Source {
+			checkpoint, err := store.NewCheckpoint(path)
+			require.NoError(t, err)
+
+			return pprof.NewS3Source(newFakeBucket(t, objects...), pprof.S3SourceOptions{
+				Bucket:     "profiles",
+				Prefix:     "checkout/",
+				Checkpoint: checkpoint,
+			})
+		}
+
+		source := newSource(objects...)
+
+		profiles, err := source.Profiles(ctx)
+		require.NoError(t, err)
+		require.Len(t, profiles, 2)
+
+		// The run failed before committing, so they are loaded again.
+		source = newSource(objects...)
+
+		profiles, err = source.Profiles(ctx)
+		require.NoError(t, err)
+		require.Len(t, profiles, 2)
+		require.NoError(t, source.Commit())
+
+		// Uploaded within the same second as the last object consumed, after it was listed.
+		objects = append(objects, bucketObject{key: "checkout/3.pprof", lastModified: lastRun, content: cpuProfile})
+
+		source = newSource(objects...)
+
+		profiles, err = source.Profiles(ctx)
+		require.NoError(t, err)
+		require.Len(t, profiles, 1)
+		require.NoError(t, source.Commit())
+
+		profiles, err = newSource(objects...).Profiles(ctx)
+		require.NoError(t, err)
+		require.Empty(t, profiles)
+	})
+
+	t.Run("when an object cannot be downloaded, then it and the newer ones are loaded on the next run", func(t *testing.T) {
+		t.Parallel()
+
+		checkpoint, err := store.NewCheckpoint(filepath.Join(t.TempDir(), "checkout.json"))
+		require.NoError(t, err)
+
+		objects := []bucketObject{
+			{key: "checkout/1.pprof", lastModified: lastRun, content: cpuProfile},
+			{key: "checkout/2.pprof", lastModified: lastRun.Add(time.Second), content: cpuProfile, unavailable: true},
+			{key: "checkout/3.pprof", lastModified: lastRun.Add(2 * time.Second), content: cpuProfile},
+		}
+
+		opts := pprof.S3SourceOptions{Bucket: "profiles", Prefix: "checkout/", Checkpoint: checkpoint}
+
+		source := pprof.NewS3Source(newFakeBucket(t, objects...), opts)
+
+		profiles, err := source.Profiles(ctx)
+		require.Error(t, err)
+		require.Len(t, profiles, 1)
+		require.NoError(t, source.Commit())
+
+		objects[1].unavailable = false
+
+		profiles, err = pprof.NewS3Source(newFakeBucket(t, objects...), opts).Profiles(ctx)
+		require.NoError(t, err)
+		require.Len(t, profiles, 2)
+	})
+
+	t.Run("when some objects are invalid, then the others are returned alongside the error", func(t *testing.T) {
+		t.Parallel()
+
+		client := newFakeBucket(t,
+			bucketObject{key: "checkout/1.pprof", lastModified: lastRun, content: cpuProfile},
+			bucketObject{key: "checkout/garbage.pprof", lastModified: lastRun, content: []byte("garbage")},
+			bucketObject{key: "checkout/huge.pprof", lastModified: lastRun, content: make([]byte, len(cpuProfile)+1)},
+		)
+
+		source := pprof.NewS3Source(client, pprof.S3SourceOptions{
+			Bucket:      "profiles",
+			Prefix:      "checkout/",
+			MaxBodySize: int64(len(cpuProfile)),
+		})
+
+		profiles, err := source.Profiles(ctx)
+		require.ErrorIs(t, err, pprof.ErrUnparsableProfile)
+		require.ErrorIs(t, err, pprof.ErrBodyTooLarge)
+		require.Len(t, profiles, 1)
+	})
+
+	t.Run("when the bucket cannot be listed, then an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		client := newFakeBucket(t)
+
+		profiles, err := pprof.NewS3Source(client, pprof.S3SourceOptions{Bucket: "does-not-exist"}).Profiles(ctx)
+		require.Error(t, err)
+		require.Nil(t, profiles)
+	})
+}
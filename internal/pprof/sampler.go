@@ -0,0 +1,75 @@
+package pprof
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/macabu/cpgo/internal/store"
+)
+
+type SamplerOptions struct {
+	// Samples is how many times the source is sampled during the window.
+	Samples int
+	// Window of time the samples are spread across at random.
+	Window time.Duration
+}
+
+// Sampler samples another source at random moments across a window, keeping the samples in a store.Store until they
+// are merged, see StoreSource. Short profiles spread across hours represent the workload better than a single one,
+// e.g. with diurnal traffic.
+type Sampler struct {
+	source Source
+	store  *store.Store
+	opts   SamplerOptions
+}
+
+func NewSampler(source Source, s *store.Store, opts SamplerOptions) *Sampler {
+	if opts.Samples < 1 {
+		opts.Samples = 1
+	}
+
+	return &Sampler{
+		source: source,
+		store:  s,
+		opts:   opts,
+	}
+}
+
+// Schedule returns the random moments to sample the source at, across the window starting at `start`, in order.
+// Each of them is up to the caller, so that nothing has to wait for the whole window.
+func (s *Sampler) Schedule(start time.Time) []time.Time {
+	offsets := make([]time.Duration, s.opts.Samples)
+
+	for i := range offsets {
+		if s.opts.Window > 0 {
+			offsets[i] = rand.N(s.opts.Window)
+		}
+	}
+
+	slices.Sort(offsets)
+
+	moments := make([]time.Time, 0, len(offsets))
+
+	for _, offset := range offsets {
+		moments = append(moments, start.Add(offset))
+	}
+
+	return moments
+}
+
+// Sample the source once, putting its profiles in the store right away so they survive restarts.
+// The profiles that could be taken are kept even if others failed, and the errors are joined.
+func (s *Sampler) Sample(ctx context.Context) error {
+	sampled, err := s.source.Profiles(ctx)
+
+	errs := []error{err}
+
+	for _, prof := range sampled {
+		errs = append(errs, putProfile(s.store, prof))
+	}
+
+	return errors.Join(errs...)
+}
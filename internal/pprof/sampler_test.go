@@ -0,0 +1,91 @@
+package pprof_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/pprof"
+	"github.com/macabu/cpgo/internal/store"
+)
+
+func TestSampler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cpuProfile := newCPUProfile(t, stack{frames: []string{"main.main", "main.work"}, value: 10})
+
+	// countingSource returns a copy of the profile on every call, failing on the calls in `failing`.
+	countingSource := func(calls *atomic.Int32, failing ...int32) pprof.Source {
+		return pprof.SourceFunc(func(context.Context) ([]*profile.Profile, error) {
+			call := calls.Add(1)
+
+			for _, failed := range failing {
+				if call == failed {
+					return nil, errors.New("scrape failed")
+				}
+			}
+
+			return []*profile.Profile{cpuProfile.Copy()}, nil
+		})
+	}
+
+	t.Run("given a window, then the samples are scheduled across it in order", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int32
+
+		window := 6 * time.Hour
+		start := time.Now()
+
+		sampler := pprof.NewSampler(countingSource(&calls), nil, pprof.SamplerOptions{Samples: 12, Window: window})
+
+		moments := sampler.Schedule(start)
+		require.Len(t, moments, 12)
+
+		for i, moment := range moments {
+			require.False(t, moment.Before(start))
+			require.True(t, moment.Before(start.Add(window)))
+
+			if i > 0 {
+				require.False(t, moment.Before(moments[i-1]))
+			}
+		}
+
+		// Scheduling does not sample the source.
+		require.Zero(t, calls.Load())
+	})
+
+	t.Run("given samples, then they are kept in the store until drained", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := store.New(t.TempDir())
+		require.NoError(t, err)
+
+		var calls atomic.Int32
+
+		sampler := pprof.NewSampler(countingSource(&calls, 2), s, pprof.SamplerOptions{Samples: 3, Window: time.Hour})
+
+		require.NoError(t, sampler.Sample(ctx))
+		require.Error(t, sampler.Sample(ctx))
+		require.NoError(t, sampler.Sample(ctx))
+
+		source := pprof.NewStoreSource(s)
+
+		profiles, err := source.Profiles(ctx)
+		require.NoError(t, err)
+		require.Len(t, profiles, 2)
+
+		require.NoError(t, source.Commit())
+
+		entries, err := s.List()
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+}
@@ -0,0 +1,62 @@
+package pprof
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/macabu/cpgo/internal/discovery"
+)
+
+// Source provides the new profiles of a backend on every run.
+// The profiles that could be loaded are returned even if others failed, alongside the joined errors.
+type Source interface {
+	Profiles(ctx context.Context) ([]*profile.Profile, error)
+}
+
+// SourceFunc adapts a function to a Source.
+type SourceFunc func(ctx context.Context) ([]*profile.Profile, error)
+
+func (f SourceFunc) Profiles(ctx context.Context) ([]*profile.Profile, error) {
+	return f(ctx)
+}
+
+// Committer is implemented by the sources that consume their profiles, which must only happen once they were merged.
+type Committer interface {
+	Commit() error
+}
+
+// HTTPSource scrapes the profiles of its targets with a Fetcher.
+type HTTPSource struct {
+	fetcher     *Fetcher
+	targets     []discovery.Target
+	concurrency int
+}
+
+func NewHTTPSource(fetcher *Fetcher, targets []discovery.Target, concurrency int) *HTTPSource {
+	return &HTTPSource{
+		fetcher:     fetcher,
+		targets:     targets,
+		concurrency: concurrency,
+	}
+}
+
+// Profiles fetches the profiles of all targets, see Fetcher.FromTargets.
+func (s HTTPSource) Profiles(ctx context.Context) ([]*profile.Profile, error) {
+	return s.fetcher.FromTargets(ctx, s.targets, s.concurrency)
+}
+
+// ParseCPUProfile parses the profile in `data`, which must be a CPU profile usable for PGO.
+func ParseCPUProfile(data []byte) (*profile.Profile, error) {
+	prof, err := parseData(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnparsableProfile, err)
+	}
+
+	if !isCPUProfile(prof) {
+		return nil, ErrNotCPUProfile
+	}
+
+	return prof, nil
+}
@@ -0,0 +1,39 @@
+package pprof_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/discovery"
+	"github.com/macabu/cpgo/internal/pprof"
+)
+
+func TestHTTPSource(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	body := encode(t, newCPUProfile(t, stack{frames: []string{"main.main"}, value: 1}))
+
+	client := &http.Client{
+		Transport: mockRoundTripper(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader(body)),
+			}, nil
+		}),
+	}
+
+	targets := discovery.StaticTargets([]string{"pod-0", "pod-1"})
+
+	var source pprof.Source = pprof.NewHTTPSource(pprof.NewFetcher(client), targets, 1)
+
+	profiles, err := source.Profiles(ctx)
+	require.NoError(t, err)
+	require.Len(t, profiles, 2)
+}
@@ -0,0 +1,104 @@
+package pprof
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/macabu/cpgo/internal/store"
+)
+
+// StoreSource loads the profiles buffered in a store.Store, e.g. the ones pushed to the ingestion server.
+type StoreSource struct {
+	store *store.Store
+	// loaded are the names of the entries returned by the last call to Profiles, to be removed on Commit.
+	loaded []string
+}
+
+func NewStoreSource(s *store.Store) *StoreSource {
+	return &StoreSource{
+		store: s,
+	}
+}
+
+// Profiles loads every profile in the store. They are kept until Commit is called.
+func (s *StoreSource) Profiles(_ context.Context) ([]*profile.Profile, error) {
+	entries, err := s.store.List()
+	if err != nil {
+		return nil, fmt.Errorf("store.List: %w", err)
+	}
+
+	profiles, loaded, err := loadEntries(s.store, entries)
+
+	s.loaded = loaded
+
+	return profiles, err
+}
+
+// Commit removes the profiles loaded by the last call to Profiles from the store.
+func (s *StoreSource) Commit() error {
+	if err := s.store.Remove(s.loaded...); err != nil {
+		return fmt.Errorf("store.Remove: %w", err)
+	}
+
+	s.loaded = nil
+
+	return nil
+}
+
+// loadEntries parses the profiles of the entries of the store, returning them with the names of their entries.
+// The ones that could be loaded are returned even if others failed, alongside the joined errors. The entries that are
+// not valid profiles are removed right away, as they would fail again on every run otherwise, and the merge with them.
+func loadEntries(s *store.Store, entries []store.Entry) ([]*profile.Profile, []string, error) {
+	var (
+		profiles = make([]*profile.Profile, 0, len(entries))
+		loaded   = make([]string, 0, len(entries))
+		invalid  []string
+		errs     []error
+	)
+
+	for _, entry := range entries {
+		data, err := os.ReadFile(entry.Path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: os.ReadFile: %w", entry.Name, err))
+
+			continue
+		}
+
+		prof, err := ParseCPUProfile(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", entry.Name, err))
+			invalid = append(invalid, entry.Name)
+
+			continue
+		}
+
+		profiles = append(profiles, prof)
+		loaded = append(loaded, entry.Name)
+	}
+
+	if err := s.Remove(invalid...); err != nil {
+		errs = append(errs, fmt.Errorf("store.Remove: %w", err))
+	}
+
+	return profiles, loaded, errors.Join(errs...)
+}
+
+// putProfile encodes the profile into the store.
+func putProfile(s *store.Store, prof *profile.Profile) error {
+	var buf bytes.Buffer
+
+	if err := prof.Write(&buf); err != nil {
+		return fmt.Errorf("prof.Write: %w", err)
+	}
+
+	if _, err := s.Put(buf.Bytes()); err != nil {
+		return fmt.Errorf("store.Put: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package pprof_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/pprof"
+	"github.com/macabu/cpgo/internal/store"
+)
+
+func TestStoreSource(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cpuProfile := encode(t, newCPUProfile(t, stack{frames: []string{"main.main", "main.work"}, value: 10}))
+
+	t.Run("given buffered profiles, then they are loaded until committed", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := store.New(t.TempDir())
+		require.NoError(t, err)
+
+		_, err = s.Put(cpuProfile)
+		require.NoError(t, err)
+
+		_, err = s.Put([]byte("garbage"))
+		require.NoError(t, err)
+
+		source := pprof.NewStoreSource(s)
+
+		profiles, err := source.Profiles(ctx)
+		require.ErrorIs(t, err, pprof.ErrUnparsableProfile)
+		require.Len(t, profiles, 1)
+
+		// Pushed while the run was in progress, so it must be kept for the next run.
+		_, err = s.Put(cpuProfile)
+		require.NoError(t, err)
+
+		require.NoError(t, source.Commit())
+
+		profiles, err = pprof.NewStoreSource(s).Profiles(ctx)
+		require.NoError(t, err)
+		require.Len(t, profiles, 1)
+	})
+
+	t.Run("given an invalid profile, then it is removed right away even if the run is not committed", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := store.New(t.TempDir())
+		require.NoError(t, err)
+
+		_, err = s.Put([]byte("garbage"))
+		require.NoError(t, err)
+
+		profiles, err := pprof.NewStoreSource(s).Profiles(ctx)
+		require.ErrorIs(t, err, pprof.ErrUnparsableProfile)
+		require.Empty(t, profiles)
+
+		// The next run does not fail on it again.
+		profiles, err = pprof.NewStoreSource(s).Profiles(ctx)
+		require.NoError(t, err)
+		require.Empty(t, profiles)
+	})
+
+	t.Run("given an empty store, then there are no profiles", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := store.New(t.TempDir())
+		require.NoError(t, err)
+
+		profiles, err := pprof.NewStoreSource(s).Profiles(ctx)
+		require.NoError(t, err)
+		require.Empty(t, profiles)
+	})
+}
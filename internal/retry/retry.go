@@ -0,0 +1,145 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 30 * time.Second
+	defaultMultiplier     = 2
+	defaultJitter         = 0.5
+)
+
+// DefaultPolicy is used when no policy is configured.
+var DefaultPolicy = Policy{
+	MaxAttempts:    3,
+	InitialBackoff: defaultInitialBackoff,
+	MaxBackoff:     defaultMaxBackoff,
+	Multiplier:     defaultMultiplier,
+	Jitter:         defaultJitter,
+}
+
+type Policy struct {
+	// MaxAttempts including the first one, zero or one disables retrying.
+	MaxAttempts int
+	// InitialBackoff before the first retry, multiplied by Multiplier on every following retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction, between 0 and 1, of each backoff that is randomized.
+	Jitter float64
+}
+
+// Do calls `fn` until it succeeds, returns a permanent error, or the attempts run out. Returns the last error.
+// When the error asks to retry after a delay longer than Policy.MaxBackoff, it is returned right away.
+func Do(ctx context.Context, policy Policy, fn func(ctx context.Context) error) error {
+	policy = policy.withDefaults()
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= policy.MaxAttempts || IsPermanent(err) {
+			return err
+		}
+
+		wait := policy.backoff(attempt)
+
+		if retryAfter, ok := RetryAfter(err); ok {
+			if retryAfter > policy.MaxBackoff {
+				return err
+			}
+
+			wait = max(wait, retryAfter)
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff before the next attempt, given the number of attempts made so far.
+func (p Policy) backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	backoff = math.Min(backoff, float64(p.MaxBackoff))
+
+	return time.Duration(backoff * (1 - p.Jitter*rand.Float64()))
+}
+
+// withDefaults fills the unset backoff settings from DefaultPolicy.
+func (p Policy) withDefaults() Policy {
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultPolicy.InitialBackoff
+	}
+
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultPolicy.MaxBackoff
+	}
+
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultPolicy.Multiplier
+	}
+
+	p.Jitter = math.Max(0, math.Min(p.Jitter, 1))
+
+	return p
+}
+
+// IsPermanent reports whether any error in the chain classifies itself as permanent, hence not worth retrying.
+func IsPermanent(err error) bool {
+	var classified interface{ Permanent() bool }
+
+	return errors.As(err, &classified) && classified.Permanent()
+}
+
+// IsTransientStatus reports whether a server responding with the HTTP status code might respond successfully later on,
+// i.e. server errors, throttling and timeouts.
+func IsTransientStatus(statusCode int) bool {
+	return statusCode >= http.StatusInternalServerError ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode == http.StatusRequestTimeout
+}
+
+// RetryAfter returns the delay requested by the server, e.g. through the Retry-After header, if any.
+func RetryAfter(err error) (time.Duration, bool) {
+	var throttled interface{ RetryAfter() time.Duration }
+
+	if errors.As(err, &throttled) && throttled.RetryAfter() > 0 {
+		return throttled.RetryAfter(), true
+	}
+
+	return 0, false
+}
+
+// Permanent marks `err` as not worth retrying.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func (e *permanentError) Permanent() bool {
+	return true
+}
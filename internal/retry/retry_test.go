@@ -0,0 +1,169 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/retry"
+)
+
+type throttledError struct {
+	wait time.Duration
+}
+
+func (e throttledError) Error() string {
+	return "throttled"
+}
+
+func (e throttledError) RetryAfter() time.Duration {
+	return e.wait
+}
+
+func TestDo(t *testing.T) {
+	t.Parallel()
+
+	policy := retry.Policy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+
+	t.Run("given a transient error, then it retries until it succeeds", func(t *testing.T) {
+		t.Parallel()
+
+		attempts := 0
+
+		err := retry.Do(context.Background(), policy, func(context.Context) error {
+			attempts++
+
+			if attempts < 3 {
+				return fmt.Errorf("transient error")
+			}
+
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, attempts)
+	})
+
+	t.Run("when the attempts run out, then the last error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		attempts := 0
+		mockErr := fmt.Errorf("mock error")
+
+		err := retry.Do(context.Background(), policy, func(context.Context) error {
+			attempts++
+
+			return mockErr
+		})
+		require.ErrorIs(t, err, mockErr)
+		require.Equal(t, policy.MaxAttempts, attempts)
+	})
+
+	t.Run("given a permanent error, then it is not retried", func(t *testing.T) {
+		t.Parallel()
+
+		attempts := 0
+		mockErr := fmt.Errorf("mock error")
+
+		err := retry.Do(context.Background(), policy, func(context.Context) error {
+			attempts++
+
+			return retry.Permanent(mockErr)
+		})
+		require.ErrorIs(t, err, mockErr)
+		require.True(t, retry.IsPermanent(err))
+		require.Equal(t, 1, attempts)
+	})
+
+	t.Run("given a zero policy, then it is only attempted once", func(t *testing.T) {
+		t.Parallel()
+
+		attempts := 0
+
+		err := retry.Do(context.Background(), retry.Policy{}, func(context.Context) error {
+			attempts++
+
+			return fmt.Errorf("transient error")
+		})
+		require.Error(t, err)
+		require.Equal(t, 1, attempts)
+	})
+
+	t.Run("given a retry after, then it waits at least that long", func(t *testing.T) {
+		t.Parallel()
+
+		attempts := 0
+		start := time.Now()
+
+		err := retry.Do(context.Background(), policy, func(context.Context) error {
+			attempts++
+
+			if attempts == 1 {
+				return throttledError{wait: 5 * time.Millisecond}
+			}
+
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, attempts)
+		require.GreaterOrEqual(t, time.Since(start), 5*time.Millisecond)
+	})
+
+	t.Run("when the retry after is longer than the max backoff, then it gives up", func(t *testing.T) {
+		t.Parallel()
+
+		attempts := 0
+
+		err := retry.Do(context.Background(), policy, func(context.Context) error {
+			attempts++
+
+			return throttledError{wait: time.Hour}
+		})
+		require.ErrorAs(t, err, &throttledError{})
+		require.Equal(t, 1, attempts)
+	})
+
+	t.Run("when the context is canceled while waiting, then it stops", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		mockErr := fmt.Errorf("mock error")
+
+		err := retry.Do(ctx, retry.Policy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour}, func(context.Context) error {
+			cancel()
+
+			return mockErr
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		require.ErrorIs(t, err, mockErr)
+	})
+}
+
+func TestIsPermanent(t *testing.T) {
+	t.Parallel()
+
+	require.False(t, retry.IsPermanent(errors.New("plain error")))
+	require.True(t, retry.IsPermanent(fmt.Errorf("wrapped: %w", retry.Permanent(errors.New("plain error")))))
+}
+
+func TestIsTransientStatus(t *testing.T) {
+	t.Parallel()
+
+	for _, statusCode := range []int{http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway} {
+		require.True(t, retry.IsTransientStatus(statusCode), statusCode)
+	}
+
+	for _, statusCode := range []int{http.StatusOK, http.StatusNotFound, http.StatusUnprocessableEntity} {
+		require.False(t, retry.IsTransientStatus(statusCode), statusCode)
+	}
+}
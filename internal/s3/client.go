@@ -0,0 +1,141 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxErrorBodySize bounds how much of an error response is read.
+const maxErrorBodySize = 64 << 10
+
+type Object struct {
+	Key          string
+	LastModified time.Time
+	Size         int64
+}
+
+type Options struct {
+	// Endpoint of the S3 compatible storage, e.g. https://s3.eu-west-1.amazonaws.com or http://minio:9000.
+	Endpoint string
+	Region   string
+	// PathStyle addresses the bucket in the path instead of the host, as needed by MinIO and most S3 compatible storages.
+	PathStyle   bool
+	Credentials Credentials
+}
+
+// Client reads objects from an S3 compatible storage, such as AWS S3 or MinIO.
+type Client struct {
+	client *http.Client
+	opts   Options
+}
+
+func NewClient(client *http.Client, opts Options) *Client {
+	return &Client{
+		client: client,
+		opts:   opts,
+	}
+}
+
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+// ListObjects lists all the objects in the bucket under the prefix, following the pagination.
+func (c Client) ListObjects(ctx context.Context, bucket, prefix string) ([]Object, error) {
+	var (
+		objects           []Object
+		continuationToken string
+	)
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		body, err := c.do(ctx, bucket, "", query)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listBucketResult
+
+		err = xml.NewDecoder(body).Decode(&result)
+
+		body.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("xml.NewDecoder.Decode: %w", err)
+		}
+
+		for _, content := range result.Contents {
+			objects = append(objects, Object{Key: content.Key, LastModified: content.LastModified, Size: content.Size})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// GetObject returns the body of the object, which must be closed by the caller.
+func (c Client) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	return c.do(ctx, bucket, key, nil)
+}
+
+// do a signed GET request for the bucket and key. Returns the response body if successful.
+func (c Client) do(ctx context.Context, bucket, key string, query url.Values) (io.ReadCloser, error) {
+	endpoint, err := url.Parse(c.opts.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("url.Parse: %w", err)
+	}
+
+	switch {
+	case c.opts.PathStyle && key == "":
+		endpoint.Path = "/" + bucket
+	case c.opts.PathStyle:
+		endpoint.Path = "/" + bucket + "/" + key
+	default:
+		endpoint.Host = bucket + "." + endpoint.Host
+		endpoint.Path = "/" + key
+	}
+
+	endpoint.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+
+	// Public buckets are read anonymously.
+	if c.opts.Credentials.AccessKeyID != "" {
+		SignV4(req, c.opts.Credentials, c.opts.Region, time.Now())
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client.Do: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		return nil, newError(resp)
+	}
+
+	return resp.Body, nil
+}
@@ -0,0 +1,131 @@
+package s3_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/s3"
+)
+
+// newFakeStorage stands in for MinIO, serving a bucket with two pages of objects.
+func newFakeStorage(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/profiles", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=minio/") {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Access Denied.</Message></Error>`))
+
+			return
+		}
+
+		if r.URL.Query().Get("prefix") != "checkout/" {
+			_, _ = w.Write([]byte(`<ListBucketResult><IsTruncated>false</IsTruncated></ListBucketResult>`))
+
+			return
+		}
+
+		if r.URL.Query().Get("continuation-token") == "" {
+			_, _ = fmt.Fprint(w, `<ListBucketResult>
+  <IsTruncated>true</IsTruncated>
+  <NextContinuationToken>page-2</NextContinuationToken>
+  <Contents><Key>checkout/1.pprof</Key><LastModified>2023-08-01T10:00:00.000Z</LastModified><Size>10</Size></Contents>
+</ListBucketResult>`)
+
+			return
+		}
+
+		_, _ = fmt.Fprint(w, `<ListBucketResult>
+  <IsTruncated>false</IsTruncated>
+  <Contents><Key>checkout/2.pprof</Key><LastModified>2023-08-02T10:00:00.000Z</LastModified><Size>20</Size></Contents>
+</ListBucketResult>`)
+	})
+
+	mux.HandleFunc("/profiles/checkout/1.pprof", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("first profile"))
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("given a prefix with many pages of objects, then it lists all of them", func(t *testing.T) {
+		t.Parallel()
+
+		srv := newFakeStorage(t)
+
+		client := s3.NewClient(srv.Client(), s3.Options{
+			Endpoint:    srv.URL,
+			Region:      "us-east-1",
+			PathStyle:   true,
+			Credentials: s3.Credentials{AccessKeyID: "minio", SecretAccessKey: "minio123"},
+		})
+
+		objects, err := client.ListObjects(ctx, "profiles", "checkout/")
+		require.NoError(t, err)
+		require.EqualValues(t, []s3.Object{
+			{Key: "checkout/1.pprof", LastModified: time.Date(2023, time.August, 1, 10, 0, 0, 0, time.UTC), Size: 10},
+			{Key: "checkout/2.pprof", LastModified: time.Date(2023, time.August, 2, 10, 0, 0, 0, time.UTC), Size: 20},
+		}, objects)
+
+		body, err := client.GetObject(ctx, "profiles", "checkout/1.pprof")
+		require.NoError(t, err)
+
+		defer body.Close()
+
+		content, err := io.ReadAll(body)
+		require.NoError(t, err)
+		require.Equal(t, "first profile", string(content))
+	})
+
+	t.Run("when the storage denies access, then a permanent error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		srv := newFakeStorage(t)
+
+		client := s3.NewClient(srv.Client(), s3.Options{
+			Endpoint:    srv.URL,
+			Region:      "us-east-1",
+			PathStyle:   true,
+			Credentials: s3.Credentials{AccessKeyID: "someone-else"},
+		})
+
+		objects, err := client.ListObjects(ctx, "profiles", "checkout/")
+		require.Nil(t, objects)
+
+		var s3Err *s3.Error
+
+		require.ErrorAs(t, err, &s3Err)
+		require.Equal(t, "AccessDenied", s3Err.Code)
+		require.True(t, s3Err.Permanent())
+	})
+
+	t.Run("when the object does not exist, then an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		srv := newFakeStorage(t)
+
+		client := s3.NewClient(srv.Client(), s3.Options{Endpoint: srv.URL, PathStyle: true})
+
+		body, err := client.GetObject(ctx, "profiles", "checkout/does-not-exist.pprof")
+		require.Error(t, err)
+		require.Nil(t, body)
+	})
+}
@@ -0,0 +1,35 @@
+package s3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/macabu/cpgo/internal/retry"
+)
+
+// Error is returned by the storage for unsuccessful requests.
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func newError(resp *http.Response) *Error {
+	s3Err := &Error{StatusCode: resp.StatusCode}
+
+	// The body is best-effort, e.g. HEAD requests and some proxies do not return one.
+	_ = xml.NewDecoder(io.LimitReader(resp.Body, maxErrorBodySize)).Decode(s3Err)
+
+	return s3Err
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("s3 error (status code %v): %v: %v", e.StatusCode, e.Code, e.Message)
+}
+
+// Permanent reports whether the request would fail again, e.g. due to missing permissions or bucket.
+func (e *Error) Permanent() bool {
+	return !retry.IsTransientStatus(e.StatusCode)
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Checkpoint keeps a small state as JSON in a file, e.g. how far a source was consumed, so it survives restarts.
+type Checkpoint struct {
+	path string
+}
+
+// NewCheckpoint at `path`, whose directory is created if it does not exist.
+func NewCheckpoint(path string) (*Checkpoint, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll: %w", err)
+	}
+
+	return &Checkpoint{
+		path: path,
+	}, nil
+}
+
+// Load the state into `v`. Reports whether there was one, i.e. it was saved before.
+func (c Checkpoint) Load(v any) (bool, error) {
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return true, nil
+}
+
+// Save the state atomically, so that a crash leaves either the previous or the new one.
+func (c Checkpoint) Save(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	return writeAtomic(c.path, data)
+}
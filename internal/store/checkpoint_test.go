@@ -0,0 +1,62 @@
+package store_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/store"
+)
+
+func TestCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	type state struct {
+		Since time.Time `json:"since"`
+	}
+
+	t.Run("given a saved state, then it is loaded by another checkpoint on the same file", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "s3", "checkout.json")
+
+		checkpoint, err := store.NewCheckpoint(path)
+		require.NoError(t, err)
+
+		var loaded state
+
+		found, err := checkpoint.Load(&loaded)
+		require.NoError(t, err)
+		require.False(t, found)
+
+		saved := state{Since: time.Date(2023, time.August, 1, 12, 0, 0, 0, time.UTC)}
+		require.NoError(t, checkpoint.Save(saved))
+
+		// As after a restart.
+		checkpoint, err = store.NewCheckpoint(path)
+		require.NoError(t, err)
+
+		found, err = checkpoint.Load(&loaded)
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, saved, loaded)
+	})
+
+	t.Run("when the state is corrupted, then an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "checkout.json")
+		require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+
+		checkpoint, err := store.NewCheckpoint(path)
+		require.NoError(t, err)
+
+		var loaded state
+
+		_, err = checkpoint.Load(&loaded)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fileExt of the stored profiles, temporary files have another one so they are never listed.
+const fileExt = ".pprof"
+
+// Entry is a profile kept in the Store.
+type Entry struct {
+	Name    string
+	Path    string
+	ModTime time.Time
+}
+
+// Store keeps profiles as files in a directory, so they survive restarts until consumed.
+type Store struct {
+	dir string
+}
+
+// New Store in `dir`, which is created if it does not exist.
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll: %w", err)
+	}
+
+	return &Store{
+		dir: dir,
+	}, nil
+}
+
+// Put writes the profile atomically, so that it is never listed partially written. Returns the name of the entry.
+func (s Store) Put(data []byte) (string, error) {
+	var random [8]byte
+
+	if _, err := rand.Read(random[:]); err != nil {
+		return "", fmt.Errorf("rand.Read: %w", err)
+	}
+
+	// Names sort by the time they were put.
+	name := fmt.Sprintf("%020d-%v%v", time.Now().UnixNano(), hex.EncodeToString(random[:]), fileExt)
+
+	if err := writeAtomic(filepath.Join(s.dir, name), data); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// writeAtomic writes the file through a temporary one in the same directory, so that it is never read partially
+// written. The temporary files are never listed, as they have another extension.
+func writeAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp: %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+
+		return fmt.Errorf("tmp.Write: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("tmp.Close: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("os.Rename: %w", err)
+	}
+
+	return nil
+}
+
+// List the entries from the oldest to the newest one.
+func (s Store) List() ([]Entry, error) {
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadDir: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(dirEntries))
+
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), fileExt) {
+			continue
+		}
+
+		info, err := dirEntry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// Removed in the meantime.
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("dirEntry.Info: %w", err)
+		}
+
+		entries = append(entries, Entry{
+			Name:    dirEntry.Name(),
+			Path:    filepath.Join(s.dir, dirEntry.Name()),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	return entries, nil
+}
+
+// Remove the entries with the given names, ignoring the ones that do not exist anymore.
+func (s Store) Remove(names ...string) error {
+	var errs []error
+
+	for _, name := range names {
+		// Names come from List, but are cleaned anyway so nothing outside the directory is removed.
+		err := os.Remove(filepath.Join(s.dir, filepath.Base(name)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("os.Remove: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
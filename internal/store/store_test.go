@@ -0,0 +1,61 @@
+package store_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/macabu/cpgo/internal/store"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("given profiles put in the store, then they are listed in order until removed", func(t *testing.T) {
+		t.Parallel()
+
+		dir := filepath.Join(t.TempDir(), "checkout")
+
+		s, err := store.New(dir)
+		require.NoError(t, err)
+
+		first, err := s.Put([]byte("first"))
+		require.NoError(t, err)
+
+		second, err := s.Put([]byte("second"))
+		require.NoError(t, err)
+
+		// Leftovers of an interrupted Put are not listed.
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ".tmp-123"), []byte("partial"), 0o600))
+
+		entries, err := s.List()
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		require.Equal(t, first, entries[0].Name)
+		require.Equal(t, second, entries[1].Name)
+
+		content, err := os.ReadFile(entries[1].Path)
+		require.NoError(t, err)
+		require.Equal(t, "second", string(content))
+
+		require.NoError(t, s.Remove(first, "does-not-exist.pprof"))
+
+		entries, err = s.List()
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, second, entries[0].Name)
+	})
+
+	t.Run("when the directory cannot be created, then an error is returned", func(t *testing.T) {
+		t.Parallel()
+
+		file := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(file, nil, 0o600))
+
+		s, err := store.New(filepath.Join(file, "checkout"))
+		require.Error(t, err)
+		require.Nil(t, s)
+	})
+}